@@ -1,6 +1,10 @@
 package jsonrpc2
 
-import "context"
+import (
+	"context"
+	"errors"
+	"sync"
+)
 
 // AsyncHandler wraps a Handler such that each request is handled in its own
 // goroutine. It is a convenience wrapper.
@@ -13,5 +17,191 @@ type asyncHandler struct {
 }
 
 func (h asyncHandler) Handle(ctx context.Context, conn *Conn, req *Request) {
-	go h.Handler.Handle(ctx, conn, req)
+	conn.handlerStarted(ctx)
+	go func() {
+		defer conn.handlerDone(ctx)
+		h.Handler.Handle(ctx, conn, req)
+	}()
+}
+
+// BoundedAsyncHandler wraps a Handler such that each request is handled
+// in its own goroutine, like AsyncHandler, but with at most
+// maxConcurrency requests handled at a time. Up to queueSize more
+// requests wait in a queue for their turn.
+//
+// When the queue is full, Handle blocks until there is room in it,
+// which stops the Conn from reading from its ObjectStream
+// (backpressure). Use RejectWhenFull to reject requests instead.
+func BoundedAsyncHandler(h Handler, maxConcurrency, queueSize int) *BoundedAsyncHandlerConfigurer {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &BoundedAsyncHandlerConfigurer{
+		h:        h,
+		admitted: make(chan struct{}, maxConcurrency+queueSize),
+		running:  make(chan struct{}, maxConcurrency),
+	}
+}
+
+// BoundedAsyncHandlerConfigurer is a handler created by
+// BoundedAsyncHandler.
+type BoundedAsyncHandlerConfigurer struct {
+	h Handler
+
+	// admitted holds a token for each request handled or queued, and
+	// running a token for each request handled.
+	admitted chan struct{}
+	running  chan struct{}
+
+	rejectErr *Error
+}
+
+// Handle implements Handler.
+//
+// If ctx is done while a request waits for room in the queue (for
+// instance because its HandlerTimeout expired), it is replied to with a
+// CodeInternalError error holding ctx.Err(), and a notification is
+// dropped.
+func (h *BoundedAsyncHandlerConfigurer) Handle(ctx context.Context, conn *Conn, req *Request) {
+	conn.handlerStarted(ctx)
+	if h.rejectErr != nil {
+		select {
+		case h.admitted <- struct{}{}:
+		default:
+			defer conn.handlerDone(ctx)
+			h.reject(ctx, conn, req, h.rejectErr)
+			return
+		}
+	} else {
+		select {
+		case h.admitted <- struct{}{}:
+		case <-ctx.Done():
+			defer conn.handlerDone(ctx)
+			h.reject(ctx, conn, req, &Error{Code: CodeInternalError, Message: ctx.Err().Error()})
+			return
+		}
+	}
+
+	go func() {
+		defer conn.handlerDone(ctx)
+		defer func() { <-h.admitted }()
+		h.running <- struct{}{}
+		defer func() { <-h.running }()
+		h.h.Handle(ctx, conn, req)
+	}()
+}
+
+// reject replies to req with respErr instead of handling it, or drops
+// it if it is a notification.
+func (h *BoundedAsyncHandlerConfigurer) reject(ctx context.Context, conn *Conn, req *Request, respErr *Error) {
+	if req.Notif {
+		conn.logger.Printf("jsonrpc2 handler: dropping notification %q: %s\n", req.Method, respErr.Message)
+		return
+	}
+	e := *respErr // don't share h.rejectErr
+	if err := conn.ReplyWithError(ctx, req.ID, &e); err != nil && !errors.Is(err, ErrClosed) {
+		conn.logger.Printf("jsonrpc2 handler: sending response %s: %v\n", req.ID, err)
+	}
+}
+
+// RejectWhenFull makes the handler reply to requests that arrive while
+// the queue is full with an error with the given code and message,
+// rather than waiting for room in the queue. Notifications that arrive
+// while the queue is full are dropped. The original handler `h` is
+// returned.
+func (h *BoundedAsyncHandlerConfigurer) RejectWhenFull(code int64, message string) Handler {
+	h.rejectErr = &Error{Code: code, Message: message}
+	return h
+}
+
+// KeyedAsyncHandler wraps a Handler such that requests with the same key
+// are handled one at a time, in the order they were received, while
+// requests with different keys are handled concurrently in their own
+// goroutines. The key of each request is returned by key. Requests for
+// which key returns the empty string are not ordered, and are handled
+// as by AsyncHandler.
+//
+// For example, a language server can handle requests about the same
+// document in order by using the document URI as the key.
+func KeyedAsyncHandler(h Handler, key func(*Request) string) *KeyedAsyncHandlerConfigurer {
+	return &KeyedAsyncHandlerConfigurer{
+		h:      h,
+		key:    key,
+		queues: map[keyedQueue][]keyedRequest{},
+	}
+}
+
+// KeyedAsyncHandlerConfigurer is a handler created by KeyedAsyncHandler.
+type KeyedAsyncHandlerConfigurer struct {
+	h                      Handler
+	key                    func(*Request) string
+	serializeNotifications bool
+
+	// queues holds the requests waiting to be handled, for each queue
+	// being drained by a goroutine.
+	mu     sync.Mutex
+	queues map[keyedQueue][]keyedRequest
+}
+
+// keyedQueue identifies a queue of requests handled in order.
+type keyedQueue struct {
+	key   string
+	notif bool // the queue of all notifications (see SerializeNotifications)
+}
+
+type keyedRequest struct {
+	ctx  context.Context
+	conn *Conn
+	req  *Request
+}
+
+// Handle implements Handler.
+func (h *KeyedAsyncHandlerConfigurer) Handle(ctx context.Context, conn *Conn, req *Request) {
+	q := keyedQueue{notif: h.serializeNotifications && req.Notif}
+	if !q.notif {
+		q.key = h.key(req)
+		if q.key == "" {
+			AsyncHandler(h.h).Handle(ctx, conn, req)
+			return
+		}
+	}
+
+	conn.handlerStarted(ctx)
+	h.mu.Lock()
+	pending, draining := h.queues[q]
+	h.queues[q] = append(pending, keyedRequest{ctx: ctx, conn: conn, req: req})
+	h.mu.Unlock()
+	if !draining {
+		go h.drain(q)
+	}
+}
+
+// drain handles the requests of q in order, until it is empty.
+func (h *KeyedAsyncHandlerConfigurer) drain(q keyedQueue) {
+	for {
+		h.mu.Lock()
+		pending := h.queues[q]
+		if len(pending) == 0 {
+			delete(h.queues, q)
+			h.mu.Unlock()
+			return
+		}
+		r := pending[0]
+		h.queues[q] = pending[1:]
+		h.mu.Unlock()
+
+		h.h.Handle(r.ctx, r.conn, r.req)
+		r.conn.handlerDone(r.ctx)
+	}
+}
+
+// SerializeNotifications makes the handler handle all notifications one
+// at a time, in the order they were received, regardless of their key.
+// The original handler `h` is returned.
+func (h *KeyedAsyncHandlerConfigurer) SerializeNotifications() Handler {
+	h.serializeNotifications = true
+	return h
 }
@@ -0,0 +1,243 @@
+package jsonrpc2_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestBoundedAsyncHandler(t *testing.T) {
+	const codeServerBusy = -32000
+
+	blockingHandler := func(started chan<- struct{}, release <-chan struct{}, mu *sync.Mutex, active, maxActive *int) jsonrpc2.Handler {
+		return jsonrpc2.HandlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			mu.Lock()
+			*active++
+			if *active > *maxActive {
+				*maxActive = *active
+			}
+			mu.Unlock()
+			started <- struct{}{}
+			<-release
+			mu.Lock()
+			*active--
+			mu.Unlock()
+			if err := conn.Reply(ctx, req.ID, nil); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	t.Run("limits concurrency", func(t *testing.T) {
+		ctx := context.Background()
+
+		var (
+			mu                sync.Mutex
+			active, maxActive int
+		)
+		started := make(chan struct{}, 10)
+		release := make(chan struct{})
+		h := jsonrpc2.BoundedAsyncHandler(blockingHandler(started, release, &mu, &active, &maxActive), 2, 10)
+		connA, connB := Pipe(ctx, noopHandler{}, h)
+		defer connA.Close()
+		defer connB.Close()
+
+		var waiters []jsonrpc2.Waiter
+		for i := 0; i < 5; i++ {
+			w, err := connA.DispatchCall(ctx, "m", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			waiters = append(waiters, w)
+		}
+
+		<-started
+		<-started
+		select {
+		case <-started:
+			t.Fatal("more than 2 requests handled concurrently")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		for _, w := range waiters {
+			if err := w.Wait(ctx, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if maxActive != 2 {
+			t.Errorf("got %d requests handled concurrently, want 2", maxActive)
+		}
+	})
+
+	t.Run("RejectWhenFull", func(t *testing.T) {
+		ctx := context.Background()
+
+		var (
+			mu                sync.Mutex
+			active, maxActive int
+		)
+		started := make(chan struct{}, 10)
+		release := make(chan struct{})
+		h := jsonrpc2.BoundedAsyncHandler(blockingHandler(started, release, &mu, &active, &maxActive), 1, 0).
+			RejectWhenFull(codeServerBusy, "server busy")
+		connA, connB := Pipe(ctx, noopHandler{}, h)
+		defer connA.Close()
+		defer connB.Close()
+
+		first, err := connA.DispatchCall(ctx, "m", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-started
+
+		err = connA.Call(ctx, "m", nil, nil)
+		respErr, ok := err.(*jsonrpc2.Error)
+		if !ok || respErr.Code != codeServerBusy {
+			t.Fatalf("got error %v, want code %d", err, codeServerBusy)
+		}
+
+		close(release)
+		if err := first.Wait(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("timeout while queued", func(t *testing.T) {
+		ctx := context.Background()
+
+		var (
+			mu                sync.Mutex
+			active, maxActive int
+		)
+		started := make(chan struct{}, 10)
+		release := make(chan struct{})
+		h := jsonrpc2.BoundedAsyncHandler(blockingHandler(started, release, &mu, &active, &maxActive), 1, 0)
+		a, b := net.Pipe()
+		connA := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(a), noopHandler{})
+		connB := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(b), h, jsonrpc2.HandlerTimeout("slow", 10*time.Millisecond))
+		defer connA.Close()
+		defer connB.Close()
+
+		first, err := connA.DispatchCall(ctx, "m", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-started
+
+		// The second request waits for room in the queue until its
+		// handler timeout expires.
+		err = connA.Call(ctx, "slow", nil, nil)
+		respErr, ok := err.(*jsonrpc2.Error)
+		if !ok || respErr.Code != jsonrpc2.CodeInternalError {
+			t.Fatalf("got error %v, want code %d", err, jsonrpc2.CodeInternalError)
+		}
+
+		close(release)
+		if err := first.Wait(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestKeyedAsyncHandler(t *testing.T) {
+	paramsKey := func(req *jsonrpc2.Request) string {
+		if req.Params == nil {
+			return ""
+		}
+		return string(*req.Params)
+	}
+
+	t.Run("orders by key", func(t *testing.T) {
+		ctx := context.Background()
+
+		var (
+			mu  sync.Mutex
+			got []string
+		)
+		releaseA := make(chan struct{})
+		h := jsonrpc2.HandlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			if req.Method == "a1" {
+				<-releaseA
+			}
+			mu.Lock()
+			got = append(got, req.Method)
+			mu.Unlock()
+			if err := conn.Reply(ctx, req.ID, nil); err != nil {
+				t.Error(err)
+			}
+		})
+		connA, connB := Pipe(ctx, noopHandler{}, jsonrpc2.KeyedAsyncHandler(h, paramsKey))
+		defer connA.Close()
+		defer connB.Close()
+
+		a1, err := connA.DispatchCall(ctx, "a1", "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a2, err := connA.DispatchCall(ctx, "a2", "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Requests with another key are not blocked by a1.
+		if err := connA.Call(ctx, "b1", "b", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := connA.Call(ctx, "unkeyed", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		close(releaseA)
+		for _, w := range []jsonrpc2.Waiter{a1, a2} {
+			if err := w.Wait(ctx, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if want := []string{"b1", "unkeyed", "a1", "a2"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("SerializeNotifications", func(t *testing.T) {
+		ctx := context.Background()
+
+		const n = 50
+		var (
+			wg  sync.WaitGroup
+			got []int
+		)
+		h := jsonrpc2.HandlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			var i int
+			if err := json.Unmarshal(*req.Params, &i); err != nil {
+				t.Error(err)
+			}
+			// Notifications are handled one at a time, so got needs
+			// no synchronization.
+			got = append(got, i)
+			wg.Done()
+		})
+		handler := jsonrpc2.KeyedAsyncHandler(h, paramsKey).SerializeNotifications()
+		connA, connB := Pipe(ctx, noopHandler{}, handler)
+		defer connA.Close()
+		defer connB.Close()
+
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			if err := connA.Notify(ctx, "m", i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		wg.Wait()
+		for want, i := range got {
+			if i != want {
+				t.Fatalf("got notification %d at position %d", i, want)
+			}
+		}
+	})
+}
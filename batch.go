@@ -0,0 +1,88 @@
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+)
+
+// Batch is a set of requests and notifications that are sent to the
+// peer as a single JSON-RPC batch (see
+// http://www.jsonrpc.org/specification#batch). Create a Batch with
+// (*Conn).NewBatch, add requests to it with Call and Notify, and send
+// it with Send.
+type Batch struct {
+	conn  *Conn
+	msgs  []*anyMessage
+	calls []*call
+	err   error // the first error from Call or Notify
+	sent  bool
+}
+
+// NewBatch returns an empty batch of requests to send on c.
+func (c *Conn) NewBatch() *Batch {
+	return &Batch{conn: c}
+}
+
+// Call adds a JSON-RPC call to the batch, and returns a Waiter that
+// receives the response once the batch has been sent. If the batch
+// fails to send, Wait returns the error returned by Send.
+//
+// The params member is omitted from the JSON-RPC request if the given
+// params is nil. Use json.RawMessage("null") to send a JSON-RPC request
+// with its params member set to null.
+func (b *Batch) Call(method string, params interface{}, opts ...CallOption) Waiter {
+	req, err := newRequest(method, params, false, opts)
+	if err != nil {
+		req = &Request{Method: method}
+		b.setErr(err)
+	}
+	call := b.conn.newCall(req)
+	b.msgs = append(b.msgs, &anyMessage{request: req})
+	b.calls = append(b.calls, call)
+	return Waiter{call: call}
+}
+
+// Notify adds a JSON-RPC notification to the batch.
+//
+// The params member is omitted from the JSON-RPC request if the given
+// params is nil. Use json.RawMessage("null") to send a JSON-RPC request
+// with its params member set to null.
+func (b *Batch) Notify(method string, params interface{}, opts ...CallOption) {
+	req, err := newRequest(method, params, true, opts)
+	if err != nil {
+		b.setErr(err)
+		return
+	}
+	b.msgs = append(b.msgs, &anyMessage{request: req})
+}
+
+// Send sends all requests of the batch to the peer in a single
+// message. It returns an error if a request of the batch could not be
+// created, or if the batch could not be sent. A Batch may only be sent
+// once.
+func (b *Batch) Send(ctx context.Context) error {
+	if b.sent {
+		return errors.New("jsonrpc2: batch already sent")
+	}
+	b.sent = true
+
+	err := b.err
+	if err == nil && len(b.msgs) == 0 {
+		err = errors.New("jsonrpc2: invalid empty batch")
+	}
+	if err != nil {
+		for _, call := range b.calls {
+			call.done <- err
+			close(call.done)
+		}
+		return err
+	}
+	// If the batch is not sent, send completes the calls with the error.
+	return b.conn.send(ctx, &anyMessage{batch: b.msgs}, b.calls...)
+}
+
+func (b *Batch) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
@@ -0,0 +1,162 @@
+package jsonrpc2_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestBatch(t *testing.T) {
+	t.Run("Send", func(t *testing.T) {
+		ctx := context.Background()
+
+		notified := make(chan string, 1)
+		handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			if req.Notif {
+				notified <- req.Method
+				return
+			}
+			var s string
+			if err := json.Unmarshal(*req.Params, &s); err != nil {
+				t.Error(err)
+			}
+			if err := conn.Reply(ctx, req.ID, req.Method+" "+s); err != nil {
+				t.Error(err)
+			}
+		})
+		connA, connB := Pipe(ctx, noopHandler{}, handler)
+		defer connA.Close()
+		defer connB.Close()
+
+		b := connA.NewBatch()
+		w1 := b.Call("a", "x")
+		b.Notify("n", nil)
+		w2 := b.Call("b", "y")
+		if err := b.Send(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		for w, want := range map[jsonrpc2.Waiter]string{w1: "a x", w2: "b y"} {
+			var got string
+			if err := w.Wait(ctx, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("got result %q, want %q", got, want)
+			}
+		}
+		if got, want := <-notified, "n"; got != want {
+			t.Errorf("got notification %q, want %q", got, want)
+		}
+	})
+
+	t.Run("single message", func(t *testing.T) {
+		ctx := context.Background()
+
+		a, b := net.Pipe()
+		defer a.Close()
+		conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(b), noopHandler{})
+		defer conn.Close()
+
+		batch := conn.NewBatch()
+		batch.Call("a", nil)
+		batch.Notify("n", nil)
+		batch.Call("b", nil, jsonrpc2.PickID(jsonrpc2.ID{Str: "x", IsString: true}))
+		go func() {
+			if err := batch.Send(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		var reqs []*jsonrpc2.Request
+		if err := json.NewDecoder(a).Decode(&reqs); err != nil {
+			t.Fatal(err)
+		}
+		if len(reqs) != 3 {
+			t.Fatalf("got %d requests, want 3", len(reqs))
+		}
+		if reqs[0].Method != "a" || reqs[0].Notif {
+			t.Errorf("got request %+v, want call to a", reqs[0])
+		}
+		if reqs[1].Method != "n" || !reqs[1].Notif {
+			t.Errorf("got request %+v, want notification n", reqs[1])
+		}
+		if want := (jsonrpc2.ID{Str: "x", IsString: true}); reqs[2].ID != want {
+			t.Errorf("got ID %s, want %s", reqs[2].ID, want)
+		}
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		ctx := context.Background()
+
+		connA, connB := Pipe(ctx, noopHandler{}, noopHandler{})
+		defer connA.Close()
+		defer connB.Close()
+
+		b := connA.NewBatch()
+		w := b.Call("a", func() {})
+		err := b.Send(ctx)
+		if err == nil {
+			t.Fatal("got nil error, want error")
+		}
+		if got := w.Wait(ctx, nil); got != err {
+			t.Errorf("got Wait error %v, want %v", got, err)
+		}
+	})
+
+	t.Run("closed while sending", func(t *testing.T) {
+		for name, queued := range map[string]bool{"write": false, "OutboundQueue": true} {
+			t.Run(name, func(t *testing.T) {
+				ctx := context.Background()
+
+				// The peer never reads, so writes block.
+				a, b := net.Pipe()
+				defer a.Close()
+				var opts []jsonrpc2.ConnOpt
+				if queued {
+					opts = append(opts, jsonrpc2.OutboundQueue(0))
+				}
+				conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(b), noopHandler{}, opts...)
+
+				if queued {
+					// Keep the writer goroutine busy, so that the
+					// batch waits for room in the queue.
+					if err := conn.Notify(ctx, "n", nil); err != nil {
+						t.Fatal(err)
+					}
+				}
+				batch := conn.NewBatch()
+				w := batch.Call("a", nil)
+				sent := make(chan error, 1)
+				go func() { sent <- batch.Send(ctx) }()
+
+				time.Sleep(20 * time.Millisecond)
+				conn.Close()
+
+				if err := <-sent; !errors.Is(err, jsonrpc2.ErrClosed) {
+					t.Errorf("got Send error %v, want ErrClosed", err)
+				}
+				if err := w.Wait(ctx, nil); !errors.Is(err, jsonrpc2.ErrClosed) {
+					t.Errorf("got Wait error %v, want ErrClosed", err)
+				}
+			})
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		ctx := context.Background()
+
+		connA, connB := Pipe(ctx, noopHandler{}, noopHandler{})
+		defer connA.Close()
+		defer connB.Close()
+
+		if err := connA.NewBatch().Send(ctx); err == nil {
+			t.Fatal("got nil error, want error")
+		}
+	})
+}
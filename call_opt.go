@@ -29,8 +29,9 @@ func ExtraField(name string, value interface{}) CallOption {
 }
 
 // PickID returns a call option which sets the ID on a request. Care must be
-// taken to ensure there are no conflicts with any previously picked ID, nor
-// with the default sequence ID.
+// taken to ensure there are no conflicts with the IDs of other requests:
+// the call fails if the ID is already used by a request awaiting its
+// response.
 func PickID(id ID) CallOption {
 	return callOptionFunc(func(r *Request) error {
 		r.ID = id
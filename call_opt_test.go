@@ -108,14 +108,9 @@ func TestExtraField(t *testing.T) {
 			}
 		}
 		var sessionID string
-		for _, field := range req.ExtraFields {
-			if field.Name != "sessionId" {
-				continue
-			}
-			var ok bool
-			sessionID, ok = field.Value.(string)
-			if !ok {
-				t.Errorf("\"sessionId\" is not a string: %v", field.Value)
+		if field, ok := req.ExtraField("sessionId"); ok {
+			if err := field.Decode(&sessionID); err != nil {
+				t.Errorf("\"sessionId\" is not a string: %v", err)
 			}
 		}
 		if sessionID == "" {
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net"
 	"reflect"
 	"strings"
@@ -39,6 +40,27 @@ func TestVSCodeObjectCodec_ReadObject(t *testing.T) {
 	}
 }
 
+func TestVSCodeObjectCodec_ReadObject_malformed(t *testing.T) {
+	s := "Content-Length: 7\r\n\r\n{\"a\":[}" + "Content-Length: 3\r\n\r\n789"
+	r := bufio.NewReader(strings.NewReader(s))
+
+	var v interface{}
+	err := (jsonrpc2.VSCodeObjectCodec{}).ReadObject(r, &v)
+	var decodeErr *jsonrpc2.DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("got error %v, want *jsonrpc2.DecodeError", err)
+	}
+
+	// The malformed message is skipped.
+	var i int
+	if err := (jsonrpc2.VSCodeObjectCodec{}).ReadObject(r, &i); err != nil {
+		t.Fatal(err)
+	}
+	if want := 789; i != want {
+		t.Errorf("got %v, want %v", i, want)
+	}
+}
+
 func TestPlainObjectCodec(t *testing.T) {
 	type Message struct {
 		One   string
@@ -81,3 +103,34 @@ func TestPlainObjectCodec(t *testing.T) {
 		t.Fatalf("got %v, want %v", res, req)
 	}
 }
+
+func TestBufferedObjectStream_WriteObjects(t *testing.T) {
+	var rwc writeCounter
+	stream := jsonrpc2.NewBufferedStream(&rwc, jsonrpc2.VSCodeObjectCodec{})
+	w, ok := stream.(jsonrpc2.ObjectsWriter)
+	if !ok {
+		t.Fatalf("got %T, want an ObjectsWriter", stream)
+	}
+	if err := w.WriteObjects([]interface{}{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Content-Length: 1\r\n\r\n1Content-Length: 1\r\n\r\n2Content-Length: 1\r\n\r\n3"; rwc.String() != want {
+		t.Errorf("got %q, want %q", rwc.String(), want)
+	}
+	if rwc.writes != 1 {
+		t.Errorf("got %d writes, want 1", rwc.writes)
+	}
+}
+
+// writeCounter is an io.ReadWriteCloser that counts the writes to it.
+type writeCounter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func (w *writeCounter) Close() error { return nil }
@@ -5,11 +5,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"strconv"
 	"sync"
+	"time"
 )
 
 // Conn is a JSON-RPC client/server connection. The JSON-RPC protocol
@@ -20,12 +22,28 @@ type Conn struct {
 
 	h Handler
 
-	mu      sync.Mutex
-	closed  bool
-	seq     uint64
-	pending map[ID]*call
+	mu           sync.Mutex
+	closed       bool
+	err          error // the error returned by Err once closed
+	shuttingDown bool
+	seq          uint64
+	pending      map[ID]*call
+
+	// activeHandlers is the number of handlers running for inbound
+	// requests (see handlerStarted).
+	activeHandlers int
+
+	// handling holds the contexts of the inbound requests that have
+	// not been replied to yet, by request ID.
+	handling map[ID]*handlerContext
 
-	sending sync.Mutex
+	// inboundBatches holds the batches received from the peer whose
+	// requests have not all been answered yet, by request ID.
+	inboundBatches map[ID]*inboundBatch
+
+	// sending holds a value while a message is written to the stream,
+	// so that writes don't interleave.
+	sending chan struct{}
 
 	cancelCtx  context.CancelFunc
 	disconnect chan struct{}
@@ -33,8 +51,29 @@ type Conn struct {
 	logger Logger
 
 	// Set by ConnOpt funcs.
-	onRecv []func(*Request, *Response)
-	onSend []func(*Request, *Response)
+	onRecv            []func(*Request, *Response)
+	onSend            []func(*Request, *Response)
+	cancelMethod      string
+	handlerTimeouts   map[string]time.Duration
+	tolerateMalformed bool
+	normalizeID       func(ID) ID
+	stringIDs         bool
+	generateID        IDGenerator
+	keepaliveMethod   string
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+	idleTimeout       time.Duration
+
+	// activity receives a value when a message is sent or received,
+	// if IdleTimeout is used.
+	activity chan struct{}
+
+	// outbound holds the messages to write, if OutboundQueue is used.
+	outbound *outboundQueue
+
+	// readStart, if not nil, is closed once the Conn may start reading
+	// messages (see readAfter).
+	readStart <-chan struct{}
 }
 
 var _ JSONRPC2 = (*Conn)(nil)
@@ -53,12 +92,15 @@ func NewConn(ctx context.Context, stream ObjectStream, h Handler, opts ...ConnOp
 	ctx, cancel := context.WithCancel(ctx)
 
 	c := &Conn{
-		stream:     stream,
-		h:          h,
-		pending:    map[ID]*call{},
-		cancelCtx:  cancel,
-		disconnect: make(chan struct{}),
-		logger:     log.New(os.Stderr, "", log.LstdFlags),
+		stream:         stream,
+		h:              h,
+		pending:        map[ID]*call{},
+		handling:       map[ID]*handlerContext{},
+		inboundBatches: map[ID]*inboundBatch{},
+		sending:        make(chan struct{}, 1),
+		cancelCtx:      cancel,
+		disconnect:     make(chan struct{}),
+		logger:         log.New(os.Stderr, "", log.LstdFlags),
 	}
 	for _, opt := range opts {
 		if opt == nil {
@@ -66,6 +108,16 @@ func NewConn(ctx context.Context, stream ObjectStream, h Handler, opts ...ConnOp
 		}
 		opt(c)
 	}
+	if c.idleTimeout > 0 {
+		c.activity = make(chan struct{}, 1)
+		go c.watchIdle()
+	}
+	if c.outbound != nil {
+		go c.writeMessages()
+	}
+	if c.keepaliveInterval > 0 {
+		go c.keepalive()
+	}
 	go c.readMessages(ctx)
 
 	go func() {
@@ -82,6 +134,119 @@ func (c *Conn) Close() error {
 	return c.close(nil)
 }
 
+// Err returns nil while the connection is open. Once the connection is
+// closed, it returns an error that wraps ErrClosed and the cause of the
+// disconnection: for example io.EOF if the peer hung up, or a decoding
+// error if it sent a malformed message. If the connection was closed
+// with Close or Shutdown, or because the context passed to NewConn was
+// done, Err returns ErrClosed itself.
+//
+// Use errors.Is(err, ErrClosed) to check whether an error indicates that
+// the connection is closed, since operations on the connection
+// (including waiting for the response to a call) also return this
+// error.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// shutdownPollInterval is how often Shutdown checks whether the
+// connection has become idle.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown gracefully closes the JSON-RPC connection. It first stops
+// handling inbound requests: requests received from then on are replied
+// to with an error, and notifications are dropped. It then waits for
+// the running handlers to return and for the outgoing calls to receive
+// their response, and finally closes the connection, once the messages
+// being sent have been written to the stream.
+//
+// Handlers are only waited for if they are synchronous or are wrapped
+// in one of the async handlers of this package (AsyncHandler,
+// BoundedAsyncHandler or KeyedAsyncHandler).
+//
+// If ctx is done before the connection is idle, Shutdown closes the
+// connection anyway and returns ctx.Err().
+func (c *Conn) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	c.shuttingDown = true
+	c.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !c.idle() {
+		select {
+		case <-ctx.Done():
+			c.close(nil)
+			return ctx.Err()
+		case <-c.disconnect:
+			return ErrClosed
+		case <-ticker.C:
+		}
+	}
+
+	if c.outbound != nil {
+		if err := c.flush(ctx); err != nil {
+			c.close(nil)
+			return err
+		}
+	}
+	if err := c.lockSending(ctx); err != nil {
+		c.close(nil)
+		return err
+	}
+	defer c.unlockSending()
+	return c.close(nil)
+}
+
+// idle reports whether c has no running handlers and no pending calls,
+// apart from abandoned ones (see cancelCall).
+func (c *Conn) idle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.activeHandlers > 0 {
+		return false
+	}
+	for _, cc := range c.pending {
+		if !cc.abandoned {
+			return false
+		}
+	}
+	return true
+}
+
+// handlerStarted records that a handler started running for an inbound
+// request, so that Shutdown waits for it, and so that the context ctx
+// of a notification is not released before the handler is done.
+// Handlers that handle requests in the background must call it before
+// returning from Handle, and call handlerDone when they are done.
+func (c *Conn) handlerStarted(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.activeHandlers++
+	c.mu.Unlock()
+	if hc, ok := ctx.Value(handlerContextKey).(*handlerContext); ok {
+		hc.started()
+	}
+}
+
+// handlerDone records that a handler started with handlerStarted is
+// done.
+func (c *Conn) handlerDone(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.activeHandlers--
+	c.mu.Unlock()
+	if hc, ok := ctx.Value(handlerContextKey).(*handlerContext); ok {
+		hc.done()
+	}
+}
+
 // Call initiates a JSON-RPC call using the specified method and params, and
 // waits for the response. If the response is successful, its result is stored
 // in result (a pointer to a value that can be JSON-unmarshaled into);
@@ -105,28 +270,26 @@ func (c *Conn) DisconnectNotify() <-chan struct{} {
 // proxy to receive the response. Only use this function if you need to do work
 // after dispatching the request, otherwise use Call.
 //
+// If ctx is done before the request is written, ctx.Err() is returned.
+// If the stream implements WriteDeadlineSetter (like the streams of a
+// net.Conn created by NewBufferedStream and NewPlainObjectStream), a
+// write in progress is interrupted then, and the connection is closed
+// since the stream may hold part of the request. Otherwise, a write in
+// progress can't be interrupted, and DispatchCall returns once it is
+// done.
+//
 // The params member is omitted from the JSON-RPC request if the given params is
 // nil. Use json.RawMessage("null") to send a JSON-RPC request with its params
 // member set to null.
 func (c *Conn) DispatchCall(ctx context.Context, method string, params interface{}, opts ...CallOption) (Waiter, error) {
-	req := &Request{Method: method}
-	for _, opt := range opts {
-		if opt == nil {
-			continue
-		}
-		if err := opt.apply(req); err != nil {
-			return Waiter{}, err
-		}
-	}
-	if params != nil {
-		if err := req.SetParams(params); err != nil {
-			return Waiter{}, err
-		}
-	}
-	call, err := c.send(ctx, &anyMessage{request: req}, true)
+	req, err := newRequest(method, params, false, opts)
 	if err != nil {
 		return Waiter{}, err
 	}
+	call := c.newCall(req)
+	if err := c.send(ctx, &anyMessage{request: req}, call); err != nil {
+		return Waiter{}, err
+	}
 	return Waiter{call: call}, nil
 }
 
@@ -138,44 +301,55 @@ func (c *Conn) DispatchCall(ctx context.Context, method string, params interface
 // nil. Use json.RawMessage("null") to send a JSON-RPC request with its params
 // member set to null.
 func (c *Conn) Notify(ctx context.Context, method string, params interface{}, opts ...CallOption) error {
-	req := &Request{Method: method, Notif: true}
+	req, err := newRequest(method, params, true, opts)
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, &anyMessage{request: req})
+}
+
+// newRequest creates a request or notification with the given method
+// and params, and applies opts to it.
+func newRequest(method string, params interface{}, notif bool, opts []CallOption) (*Request, error) {
+	req := &Request{Method: method, Notif: notif}
 	for _, opt := range opts {
 		if opt == nil {
 			continue
 		}
 		if err := opt.apply(req); err != nil {
-			return err
+			return nil, err
 		}
 	}
 	if params != nil {
 		if err := req.SetParams(params); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	_, err := c.send(ctx, &anyMessage{request: req}, false)
-	return err
+	return req, nil
 }
 
 // Reply sends a successful response with a result.
+//
+// As with DispatchCall, ctx bounds the time spent sending the response.
+// However, if ctx is already done (for instance, if it is the context of
+// a handler whose request was canceled or timed out), the response is
+// still sent, without time limit.
 func (c *Conn) Reply(ctx context.Context, id ID, result interface{}) error {
 	resp := &Response{ID: id}
 	if err := resp.SetResult(result); err != nil {
 		return err
 	}
-	_, err := c.send(ctx, &anyMessage{response: resp}, false)
-	return err
+	return c.send(ctx, &anyMessage{response: resp})
 }
 
 // ReplyWithError sends a response with an error.
 func (c *Conn) ReplyWithError(ctx context.Context, id ID, respErr *Error) error {
-	_, err := c.send(ctx, &anyMessage{response: &Response{ID: id, Error: respErr}}, false)
-	return err
+	return c.send(ctx, &anyMessage{response: &Response{ID: id, Error: respErr}})
 }
 
 // SendResponse sends resp to the peer. It is lower level than (*Conn).Reply.
 func (c *Conn) SendResponse(ctx context.Context, resp *Response) error {
-	_, err := c.send(ctx, &anyMessage{response: resp}, false)
-	return err
+	return c.send(ctx, &anyMessage{response: resp})
 }
 
 func (c *Conn) close(cause error) error {
@@ -186,14 +360,24 @@ func (c *Conn) close(cause error) error {
 		return ErrClosed
 	}
 
-	for _, call := range c.pending {
+	// The calls are removed from pending, so that a response read
+	// concurrently is ignored.
+	for id, call := range c.pending {
 		close(call.done)
+		delete(c.pending, id)
+	}
+	for _, hc := range c.handling {
+		hc.release()
 	}
 
 	if cause != nil && cause != io.EOF && cause != io.ErrUnexpectedEOF {
 		c.logger.Printf("jsonrpc2: protocol error: %v\n", cause)
 	}
 
+	c.err = ErrClosed
+	if cause != nil {
+		c.err = &closedError{cause: cause}
+	}
 	close(c.disconnect)
 	c.cancelCtx()
 	c.closed = true
@@ -201,140 +385,575 @@ func (c *Conn) close(cause error) error {
 }
 
 func (c *Conn) readMessages(ctx context.Context) {
+	if c.readStart != nil {
+		select {
+		case <-c.readStart:
+		case <-c.disconnect:
+			return
+		}
+	}
 	for {
 		var m anyMessage
 		err := c.stream.ReadObject(&m)
+		var decodeErr *DecodeError
+		if err != nil && c.tolerateMalformed && errors.As(err, &decodeErr) {
+			c.replyToMalformed(ctx, decodeErr.Err)
+			continue
+		}
 		if err != nil {
 			c.close(err)
 			return
 		}
+		c.active()
 
 		switch {
-		// TODO: handle the case where both request and response are nil.
-
 		case m.request != nil:
-			for _, onRecv := range c.onRecv {
-				onRecv(m.request, nil)
-			}
-			c.h.Handle(ctx, c, m.request)
+			c.handleRequest(ctx, m.request)
 
 		case m.response != nil:
-			resp := m.response
-			id := resp.ID
-			c.mu.Lock()
-			call := c.pending[id]
-			delete(c.pending, id)
-			c.mu.Unlock()
+			c.handleResponse(m.response)
 
-			var req *Request
-			if call != nil {
-				call.response = resp
-				req = call.request
+		case len(m.batch) > 0:
+			if err := invalidBatchElement(m.batch); err != nil && !c.tolerateMalformed {
+				c.close(err)
+				return
 			}
+			c.handleBatch(ctx, m.batch)
+
+		case c.tolerateMalformed:
+			c.replyToMalformed(ctx, errEmptyMessage)
+
+		default:
+			c.close(errEmptyMessage)
+			return
+		}
+	}
+}
+
+// errEmptyMessage is the error for messages read by an ObjectStream
+// that are neither a request nor a response.
+var errEmptyMessage = errors.New("jsonrpc2: message is neither a request nor a response")
+
+// malformedReplyTimeout bounds the time spent sending the response to
+// a malformed message, during which no other message is read.
+const malformedReplyTimeout = 10 * time.Second
+
+// replyToMalformed replies to a message that could not be decoded with
+// a malformedResponse.
+func (c *Conn) replyToMalformed(ctx context.Context, err error) {
+	c.logger.Printf("jsonrpc2: ignoring malformed message: %v\n", err)
 
-			for _, onRecv := range c.onRecv {
-				onRecv(req, resp)
+	ctx, cancel := context.WithTimeout(ctx, malformedReplyTimeout)
+	defer cancel()
+	m := &anyMessage{response: malformedResponse(err), unsolicited: true}
+	if err := c.send(ctx, m); err != nil && !errors.Is(err, ErrClosed) {
+		c.logger.Printf("jsonrpc2: sending response to malformed message: %v\n", err)
+	}
+}
+
+// malformedResponse returns the response to a message that could not
+// be decoded because of err: a CodeParseError error if it is not valid
+// JSON, or a CodeInvalidRequest error otherwise. The response has a
+// null ID, since the ID of the message is unknown.
+func malformedResponse(err error) *Response {
+	respErr := &Error{Code: CodeInvalidRequest, Message: "Invalid Request"}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		respErr = &Error{Code: CodeParseError, Message: "Parse error"}
+	}
+	return &Response{ID: ID{IsNull: true}, Error: respErr}
+}
+
+func (c *Conn) handleRequest(ctx context.Context, req *Request) {
+	for _, onRecv := range c.onRecv {
+		onRecv(req, nil)
+	}
+
+	if c.cancelMethod != "" && req.Notif && req.Method == c.cancelMethod {
+		c.handleCancel(req)
+		return
+	}
+	if c.keepaliveMethod != "" && !req.Notif && req.Method == c.keepaliveMethod {
+		c.handlePing(ctx, req)
+		return
+	}
+
+	c.mu.Lock()
+	shuttingDown := c.shuttingDown
+	c.mu.Unlock()
+	if shuttingDown {
+		if !req.Notif {
+			respErr := &Error{Code: CodeInternalError, Message: "connection is shutting down"}
+			if err := c.ReplyWithError(ctx, req.ID, respErr); err != nil && !errors.Is(err, ErrClosed) {
+				c.logger.Printf("jsonrpc2: sending response %s: %v\n", req.ID, err)
 			}
+		}
+		return
+	}
+
+	ctx, cancel := c.requestContext(ctx, req)
+	hc := &handlerContext{release: cancel, replied: req.Notif}
+	ctx = context.WithValue(ctx, handlerContextKey, hc)
+	if !req.Notif {
+		c.mu.Lock()
+		c.handling[req.ID] = hc
+		c.mu.Unlock()
+	}
+
+	c.handlerStarted(ctx)
+	defer c.handlerDone(ctx)
+	c.h.Handle(ctx, c, req)
+}
+
+// awaitingReply reports whether the request with the given ID, handled
+// with ctx, still awaits its response. It returns true if ctx is not
+// the context of a handler of c.
+func (c *Conn) awaitingReply(ctx context.Context, id ID) bool {
+	hc, ok := ctx.Value(handlerContextKey).(*handlerContext)
+	if !ok {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.handling[id] == hc
+}
+
+// handlerContext tracks the handling of an inbound request, so that
+// the context passed to its handler is released once the request is
+// replied to and the handler returned (or, if the handler continues in
+// the background, once it is done; see handlerStarted). Since there is
+// no response to a notification, the context of a notification is
+// released once its handler is done.
+type handlerContext struct {
+	mu      sync.Mutex
+	running int  // number of handlers running
+	replied bool // or the request is a notification
+	release context.CancelFunc
+}
+
+func (hc *handlerContext) started() {
+	hc.mu.Lock()
+	hc.running++
+	hc.mu.Unlock()
+}
+
+func (hc *handlerContext) done() {
+	hc.mu.Lock()
+	hc.running--
+	release := hc.running == 0 && hc.replied
+	hc.mu.Unlock()
+	if release {
+		hc.release()
+	}
+}
+
+// reply records that the request was replied to.
+func (hc *handlerContext) reply() {
+	hc.mu.Lock()
+	hc.replied = true
+	release := hc.running == 0
+	hc.mu.Unlock()
+	if release {
+		hc.release()
+	}
+}
+
+// requestContext returns the context passed to the handler of req,
+// derived from the connection context ctx. It carries c and req (see
+// ConnFromContext and RequestFromContext) and is done when the
+// connection closes or when the handler timeout for req.Method
+// expires. The context of a request (but not of a notification) is
+// also canceled when the peer cancels it (see PropagateCancellation).
+func (c *Conn) requestContext(ctx context.Context, req *Request) (context.Context, context.CancelFunc) {
+	if !req.Notif {
+		// The context of a request is not derived from ctx directly,
+		// so that it can be released once the request is replied to.
+		// It is canceled by close instead.
+		ctx = detachedContext{ctx}
+	}
+	ctx = context.WithValue(ctx, connContextKey, c)
+	ctx = context.WithValue(ctx, requestContextKey, req)
+
+	timeout, ok := c.handlerTimeouts[req.Method]
+	if !ok {
+		timeout = c.handlerTimeouts[""]
+	}
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// handleCancel cancels the context of the inbound request identified
+// by the params of the cancel notification req.
+func (c *Conn) handleCancel(req *Request) {
+	var params cancelParams
+	if req.Params == nil {
+		return
+	}
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		c.logger.Printf("jsonrpc2: invalid %s notification: %v\n", req.Method, err)
+		return
+	}
+	c.mu.Lock()
+	hc := c.handling[params.ID]
+	c.mu.Unlock()
+	if hc != nil {
+		hc.release()
+	}
+}
+
+// cancelCall stops waiting for the response to cc, whose Wait returned
+// err. If PropagateCancellation is used, cc is no longer pending and is
+// completed with err, and the peer is notified that the request was
+// canceled. Otherwise, cc stays pending, so that waiting for it again
+// still returns its response, but it is marked as abandoned so that it
+// doesn't hold back Shutdown.
+func (c *Conn) cancelCall(cc *call, err error) {
+	id := cc.request.ID
+	key := c.pendingKey(id)
+	c.mu.Lock()
+	isPending := c.pending[key] == cc
+	if isPending {
+		if c.cancelMethod == "" {
+			cc.abandoned = true
+		} else {
+			delete(c.pending, key)
+			cc.done <- err
+			close(cc.done)
+		}
+	}
+	c.mu.Unlock()
+	if !isPending || c.cancelMethod == "" {
+		return // already answered or closed, or nothing to notify
+	}
 
-			if call == nil {
-				c.logger.Printf("jsonrpc2: ignoring response #%s with no corresponding request\n", id)
-				continue
+	// The caller's context is done, so the notification is sent in the
+	// background with a context of its own.
+	go func() {
+		err := c.Notify(context.Background(), c.cancelMethod, cancelParams{ID: id})
+		if err != nil && !errors.Is(err, ErrClosed) {
+			c.logger.Printf("jsonrpc2: sending %s for request #%s: %v\n", c.cancelMethod, id, err)
+		}
+	}()
+}
+
+func (c *Conn) handleResponse(resp *Response) {
+	id := resp.ID
+	key := c.pendingKey(id)
+	c.mu.Lock()
+	call := c.pending[key]
+	delete(c.pending, key)
+	c.mu.Unlock()
+
+	var req *Request
+	if call != nil {
+		call.response = resp
+		req = call.request
+	}
+
+	for _, onRecv := range c.onRecv {
+		onRecv(req, resp)
+	}
+
+	if call == nil && id.IsNull && resp.Error != nil {
+		c.logger.Printf("jsonrpc2: peer failed to process a message: %v\n", resp.Error)
+		return
+	}
+	if call == nil {
+		c.logger.Printf("jsonrpc2: ignoring response #%s with no corresponding request\n", id)
+		return
+	}
+
+	var err error
+	if resp.Error != nil {
+		err = resp.Error
+	}
+
+	call.done <- err
+	close(call.done)
+}
+
+// handleBatch handles a batch of requests or responses. Each request
+// of the batch is passed to the handler, and the responses to them
+// are sent back to the peer as a single batch once all of them have
+// been sent (see collectBatchResponse). The invalid elements of a
+// batch of requests are answered in that batch with a
+// malformedResponse, and those of a batch of responses are ignored.
+func (c *Conn) handleBatch(ctx context.Context, batch []*anyMessage) {
+	isResponse := false
+	for _, m := range batch {
+		if m.invalid != nil {
+			c.logger.Printf("jsonrpc2: ignoring malformed message in batch: %v\n", m.invalid)
+		}
+		isResponse = isResponse || m.response != nil
+	}
+	if isResponse {
+		for _, m := range batch {
+			if m.response != nil {
+				c.handleResponse(m.response)
 			}
+		}
+		return
+	}
+
+	b := &inboundBatch{pending: map[ID]int{}}
+	var malformed []*anyMessage
+	c.mu.Lock()
+	for _, m := range batch {
+		switch {
+		case m.invalid != nil:
+			malformed = append(malformed, &anyMessage{response: malformedResponse(m.invalid)})
+			b.responses = append(b.responses, malformed[len(malformed)-1])
+		case !m.request.Notif:
+			b.pending[m.request.ID]++
+			c.inboundBatches[m.request.ID] = b
+		}
+	}
+	c.mu.Unlock()
 
-			var err error
-			if resp.Error != nil {
-				err = resp.Error
+	// If no request of the batch awaits a response, the errors are
+	// sent right away. Otherwise, they are sent with the responses to
+	// the requests, which alone go through send, so the OnSend hooks
+	// are called for them now.
+	if len(b.pending) > 0 {
+		for _, m := range malformed {
+			for _, onSend := range c.onSend {
+				onSend(nil, m.response)
 			}
+		}
+	}
+	if len(b.pending) == 0 && len(malformed) > 0 {
+		if err := c.send(ctx, &anyMessage{batch: malformed}); err != nil && !errors.Is(err, ErrClosed) {
+			c.logger.Printf("jsonrpc2: sending responses to malformed messages: %v\n", err)
+		}
+	}
 
-			call.done <- err
-			close(call.done)
+	for _, m := range batch {
+		if m.request != nil {
+			c.handleRequest(ctx, m.request)
 		}
 	}
 }
 
-func (c *Conn) send(_ context.Context, m *anyMessage, wait bool) (cc *call, err error) {
-	c.sending.Lock()
-	defer c.sending.Unlock()
+// collectBatchResponse holds back m if it is a response to a request
+// of an inbound batch. It returns the message to send: m itself if it
+// is not part of a batch, the complete batch of responses if m was
+// the last one missing, or nil if the batch still awaits other
+// responses. c.mu must be held.
+func (c *Conn) collectBatchResponse(m *anyMessage) *anyMessage {
+	id := m.response.ID
+	b := c.inboundBatches[id]
+	if b == nil {
+		return m
+	}
+
+	b.responses = append(b.responses, m)
+	b.pending[id]--
+	if b.pending[id] == 0 {
+		delete(b.pending, id)
+		delete(c.inboundBatches, id)
+	}
+	if len(b.pending) > 0 {
+		return nil
+	}
+	return &anyMessage{batch: b.responses}
+}
+
+// send writes m to the stream. The given calls, one for each request
+// in m whose response is awaited, are assigned an ID if they don't have
+// one yet and are stored so that the responses can later be associated
+// with them. If m is not sent, the calls receive the returned error,
+// unless they were completed by close in the meantime.
+func (c *Conn) send(ctx context.Context, m *anyMessage, calls ...*call) (err error) {
+	// The calls' request IDs could be changed, so we store a copy to
+	// correctly clean up pending
+	ids := make([]ID, 0, len(calls))
 
-	// double check the error isn't due to being closed while sending.
 	defer func() {
-		if err != nil {
-			c.mu.Lock()
-			if c.closed {
-				err = ErrClosed
+		if err == nil {
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// double check the error isn't due to being closed while sending.
+		if c.closed {
+			err = c.err
+		}
+		// If we fail to send this, then we need to remove the calls
+		// from the pending map so we don't block on them or pile up
+		// pending entries for unsent messages. The calls that close
+		// (or a response) already completed are left alone.
+		for i, cc := range calls {
+			if len(ids) > 0 {
+				if c.closed || c.pending[ids[i]] != cc {
+					continue
+				}
+				delete(c.pending, ids[i])
 			}
-			c.mu.Unlock()
+			cc.done <- err
+			close(cc.done)
 		}
 	}()
 
-	// m.request.ID could be changed, so we store a copy to correctly
-	// clean up pending
-	var id ID
+	if m.response != nil {
+		ctx = responseContext(ctx)
+	}
+	// With an outbound queue, the writer goroutine serializes writes
+	// instead.
+	if c.outbound == nil {
+		if err := c.lockSending(ctx); err != nil {
+			return err
+		}
+		defer c.unlockSending()
+	}
 
 	c.mu.Lock()
 	if c.closed {
 		c.mu.Unlock()
-		return nil, ErrClosed
+		return c.err
 	}
 
 	// Assign a default id if not set
-	if m.request != nil && wait {
-		cc = &call{request: m.request, seq: c.seq, done: make(chan error, 1)}
-
-		isIDUnset := len(m.request.ID.Str) == 0 && m.request.ID.Num == 0
-		if isIDUnset {
-			if m.request.ID.IsString {
-				m.request.ID.Str = strconv.FormatUint(c.seq, 10)
-			} else {
-				m.request.ID.Num = c.seq
-			}
+	keys := make(map[ID]bool, len(calls))
+	for _, cc := range calls {
+		cc.seq = c.seq
+		isIDUnset := cc.request.ID == ID{} || cc.request.ID == ID{IsString: true}
+		wantString := c.stringIDs || cc.request.ID.IsString
+		switch {
+		case isIDUnset && c.generateID != nil:
+			cc.request.ID = c.generateID()
+		case isIDUnset && cc.request.ID.IsString:
+			cc.request.ID.Str = strconv.FormatUint(c.seq, 10)
+		case isIDUnset:
+			cc.request.ID.Num = c.seq
+		}
+		if wantString && !cc.request.ID.IsString && !cc.request.ID.IsNull {
+			cc.request.ID = ID{Str: cc.request.ID.String(), IsString: true}
 		}
 		c.seq++
+
+		key := c.pendingKey(cc.request.ID)
+		if _, inUse := c.pending[key]; inUse || keys[key] {
+			c.mu.Unlock()
+			return fmt.Errorf("jsonrpc2: request ID %s is already in use", cc.request.ID)
+		}
+		keys[key] = true
+	}
+
+	// Store requests so we can later associate them with incoming
+	// responses.
+	for _, cc := range calls {
+		key := c.pendingKey(cc.request.ID)
+		c.pending[key] = cc
+		ids = append(ids, key)
 	}
 	c.mu.Unlock()
 
 	if len(c.onSend) > 0 {
-		var (
-			req  *Request
-			resp *Response
-		)
-		switch {
-		case m.request != nil:
-			req = m.request
-		case m.response != nil:
-			resp = m.response
+		msgs := []*anyMessage{m}
+		if m.batch != nil {
+			msgs = m.batch
 		}
-		for _, onSend := range c.onSend {
-			onSend(req, resp)
+		for _, m := range msgs {
+			for _, onSend := range c.onSend {
+				onSend(m.request, m.response)
+			}
 		}
 	}
 
-	// Store requests so we can later associate them with incoming
-	// responses.
-	if m.request != nil && wait {
+	// Responses to the requests of an inbound batch are sent back
+	// together, once all of them are available.
+	if m.response != nil && !m.unsolicited {
 		c.mu.Lock()
-		id = m.request.ID
-		c.pending[id] = cc
+		// The context of the handler may be released once the
+		// response is sent (see handlerContext), since writing it may
+		// depend on that context.
+		if hc := c.handling[m.response.ID]; hc != nil {
+			delete(c.handling, m.response.ID)
+			defer hc.reply()
+		}
+		m = c.collectBatchResponse(m)
 		c.mu.Unlock()
+		if m == nil {
+			return nil
+		}
 	}
 
-	// From here on, if we fail to send this, then we need to remove
-	// this from the pending map so we don't block on it or pile up
-	// pending entries for unsent messages.
-	defer func() {
-		if err != nil {
-			if cc != nil {
-				c.mu.Lock()
-				delete(c.pending, id)
-				c.mu.Unlock()
+	if c.outbound != nil {
+		return c.enqueue(ctx, m)
+	}
+	if err = c.write(ctx, m); err != nil {
+		return err
+	}
+	c.active()
+	return nil
+}
+
+// lockSending waits until no other message is being written to the
+// stream, or until ctx is done.
+func (c *Conn) lockSending(ctx context.Context) error {
+	select {
+	case c.sending <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Conn) unlockSending() { <-c.sending }
+
+// pastTime is a deadline in the past, that interrupts pending writes.
+var pastTime = time.Unix(1, 0)
+
+// write writes m to the stream. If ctx is done before m is written,
+// and the stream implements WriteDeadlineSetter, the write is
+// interrupted. Since part of m may have been written, the stream is
+// then unusable, so the connection is closed.
+func (c *Conn) write(ctx context.Context, m *anyMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ds, ok := c.stream.(WriteDeadlineSetter)
+	if !ok || ctx.Done() == nil {
+		return c.stream.WriteObject(m)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			if err := ds.SetWriteDeadline(pastTime); err != nil {
+				c.logger.Printf("jsonrpc2: can't interrupt write: %v\n", err)
 			}
+		case <-stop:
 		}
 	}()
+	err := c.stream.WriteObject(m)
+	close(stop)
+	<-stopped
+
+	if ctx.Err() == nil {
+		return err
+	}
+	if err == nil {
+		// The deadline was set after the write completed.
+		_ = ds.SetWriteDeadline(time.Time{})
+		return nil
+	}
+	c.close(fmt.Errorf("jsonrpc2: write interrupted: %w", ctx.Err()))
+	return ctx.Err()
+}
 
-	if err := c.stream.WriteObject(m); err != nil {
-		return nil, err
+// pendingKey returns the key in c.pending of the call whose request
+// has the given ID, or that is answered by a response with that ID.
+func (c *Conn) pendingKey(id ID) ID {
+	if c.normalizeID != nil {
+		return c.normalizeID(id)
 	}
-	return cc, nil
+	return id
 }
 
 // Waiter proxies an ongoing JSON-RPC call.
@@ -345,15 +964,20 @@ type Waiter struct {
 // Wait for the result of an ongoing JSON-RPC call. If the response
 // is successful, its result is stored in result (a pointer to a
 // value that can be JSON-unmarshaled into); otherwise, a non-nil
-// error is returned.
+// error is returned. If ctx is done first, ctx.Err() is returned, the
+// call is abandoned (Shutdown no longer waits for its response, but
+// Wait may be called again to get it) or, if the Conn uses
+// PropagateCancellation, canceled: the peer is notified, and waiting
+// for the call again returns ctx.Err() as well.
 func (w Waiter) Wait(ctx context.Context, result interface{}) error {
 	select {
 	case <-ctx.Done():
+		w.call.conn.cancelCall(w.call, ctx.Err())
 		return ctx.Err()
 
 	case err, ok := <-w.call.done:
 		if !ok {
-			return ErrClosed
+			return w.call.conn.Err()
 		}
 		if err != nil || result == nil {
 			return err
@@ -367,113 +991,216 @@ func (w Waiter) Wait(ctx context.Context, result interface{}) error {
 
 // call represents a JSON-RPC call over its entire lifecycle.
 type call struct {
+	conn     *Conn
 	request  *Request
 	response *Response
 	seq      uint64 // the seq of the request
 	done     chan error
+
+	// abandoned is set once a Wait for the call returned because its
+	// context was done (see cancelCall). It is guarded by conn.mu.
+	abandoned bool
+}
+
+func (c *Conn) newCall(req *Request) *call {
+	return &call{conn: c, request: req, done: make(chan error, 1)}
+}
+
+// cancelParams are the params of the notification sent to cancel a
+// request (see PropagateCancellation).
+type cancelParams struct {
+	ID ID `json:"id"`
+}
+
+// detachedContext carries the values of its parent context, but is
+// never canceled.
+type detachedContext struct {
+	context.Context
 }
 
-// anyMessage represents either a JSON Request or Response.
+// responseContext returns the context with which to send a response
+// with ctx. If ctx is done, for instance because it is the context of
+// a handler whose request was canceled or timed out, its cancellation
+// is ignored, since the request must still be answered.
+func responseContext(ctx context.Context) context.Context {
+	if ctx.Err() != nil {
+		return detachedContext{ctx}
+	}
+	return ctx
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// closedError is the error of a Conn closed because of an error. It
+// wraps both ErrClosed and the cause of the disconnection.
+type closedError struct {
+	cause error
+}
+
+func (e *closedError) Error() string {
+	return ErrClosed.Error() + ": " + e.cause.Error()
+}
+
+func (e *closedError) Is(target error) bool { return target == ErrClosed }
+
+func (e *closedError) Unwrap() error { return e.cause }
+
+// inboundBatch collects the responses to the requests of a batch
+// received from the peer.
+type inboundBatch struct {
+	pending   map[ID]int // number of unanswered requests, by ID
+	responses []*anyMessage
+}
+
+// anyMessage represents either a JSON Request or Response, or a batch
+// of them.
 type anyMessage struct {
 	request  *Request
 	response *Response
+
+	// batch holds the messages of a JSON-RPC batch, which are either
+	// all requests or all responses. If batch is set, request and
+	// response are nil.
+	batch []*anyMessage
+
+	// invalid is the error of an element of an inbound batch that is
+	// not a valid request or response, in which case the other fields
+	// are nil.
+	invalid error
+
+	// unsolicited is set for a response that doesn't answer an inbound
+	// request, even if its (null) ID matches one (see
+	// replyToMalformed).
+	unsolicited bool
 }
 
+// MarshalJSON implements json.Marshaler. Requests are encoded with
+// (Request).MarshalJSON directly, so that their raw JSON values are
+// kept as is (see marshalObject).
 func (m anyMessage) MarshalJSON() ([]byte, error) {
-	var v interface{}
 	switch {
-	case m.request != nil && m.response == nil:
-		v = m.request
-	case m.request == nil && m.response != nil:
-		v = m.response
-	}
-	if v != nil {
-		return json.Marshal(v)
+	case m.request != nil && m.response == nil && m.batch == nil:
+		return m.request.MarshalJSON()
+	case m.request == nil && m.response != nil && m.batch == nil:
+		return json.Marshal(m.response)
+	case m.request == nil && m.response == nil && len(m.batch) > 0:
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, elem := range m.batch {
+			b, err := elem.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(b)
+		}
+		buf.WriteByte(']')
+		return buf.Bytes(), nil
 	}
-	return nil, errors.New("jsonrpc2: message must have exactly one of the request or response fields set")
+	return nil, errors.New("jsonrpc2: message must have exactly one of the request, response or batch fields set")
 }
 
 func (m *anyMessage) UnmarshalJSON(data []byte) error {
-	// The presence of these fields distinguishes between the 2
-	// message types.
-	type msg struct {
-		ID     interface{}              `json:"id"`
-		Method *string                  `json:"method"`
-		Result anyValueWithExplicitNull `json:"result"`
-		Error  interface{}              `json:"error"`
-	}
-
-	var isRequest, isResponse bool
-	checkType := func(m *msg) error {
-		mIsRequest := m.Method != nil
-		mIsResponse := m.Result.null || m.Result.value != nil || m.Error != nil
-		if (!mIsRequest && !mIsResponse) || (mIsRequest && mIsResponse) {
-			return errors.New("jsonrpc2: unable to determine message type (request or response)")
-		}
-		if (mIsRequest && isResponse) || (mIsResponse && isRequest) {
-			return errors.New("jsonrpc2: batch message type mismatch (must be all requests or all responses)")
-		}
-		isRequest = mIsRequest
-		isResponse = mIsResponse
-		return nil
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if len(data) == 0 || data[0] != '[' {
+		var f messageFields
+		if err := readMessageFields(dec, &f); err != nil {
+			return err
+		}
+		isRequest, err := f.messageType()
+		if err != nil {
+			return err
+		}
+		*m = anyMessage{}
+		return m.decodeFields(&f, isRequest)
 	}
 
-	if isArray := len(data) > 0 && data[0] == '['; isArray {
-		var msgs []msg
-		if err := json.Unmarshal(data, &msgs); err != nil {
+	// The elements of a batch are decoded independently: the elements
+	// that are not valid requests or responses are kept in the batch
+	// with their error, so that the other ones can still be handled.
+	if _, err := dec.Token(); err != nil { // '['
+		return err
+	}
+	var fields []messageFields
+	var errs []error
+	for dec.More() {
+		var f messageFields
+		err := readMessageFields(dec, &f)
+		if err != nil && !errors.Is(err, errNotObject) {
 			return err
 		}
-		if len(msgs) == 0 {
-			return errors.New("jsonrpc2: invalid empty batch")
+		if err == nil {
+			_, err = f.messageType()
 		}
-		for i := range msgs {
-			if err := checkType(&msgs[i]); err != nil {
-				return err
-			}
+		fields = append(fields, f)
+		errs = append(errs, err)
+	}
+	if len(fields) == 0 {
+		return errors.New("jsonrpc2: invalid empty batch")
+	}
+
+	// The batch holds the type of messages of its first valid element
+	// (requests if there is none).
+	isRequest := true
+	for i := range fields {
+		if errs[i] == nil {
+			isRequest = fields[i].isRequest()
+			break
 		}
-	} else {
-		var m msg
-		if err := json.Unmarshal(data, &m); err != nil {
-			return err
+	}
+	batch := make([]*anyMessage, len(fields))
+	for i := range fields {
+		msg := &anyMessage{}
+		err := errs[i]
+		if err == nil && fields[i].isRequest() != isRequest {
+			err = errors.New("jsonrpc2: batch message type mismatch (must be all requests or all responses)")
 		}
-		if err := checkType(&m); err != nil {
-			return err
+		if err == nil {
+			err = msg.decodeFields(&fields[i], isRequest)
+		}
+		if err != nil {
+			msg = &anyMessage{invalid: err}
 		}
+		batch[i] = msg
 	}
+	*m = anyMessage{batch: batch}
+	return nil
+}
 
-	var v interface{}
-	switch {
-	case isRequest && !isResponse:
-		v = &m.request
-	case !isRequest && isResponse:
-		v = &m.response
+// decodeFields stores the request or response held by f in m.
+func (m *anyMessage) decodeFields(f *messageFields, isRequest bool) error {
+	if isRequest {
+		m.request = &Request{}
+		return f.decodeRequest(m.request)
 	}
-	if err := json.Unmarshal(data, v); err != nil {
+	m.response = &Response{}
+	if err := f.decodeResponse(m.response); err != nil {
 		return err
 	}
-	if !isRequest && isResponse && m.response.Error == nil && m.response.Result == nil {
-		m.response.Result = &jsonNull
-	}
+	withExplicitNullResult(m.response)
 	return nil
 }
 
-// anyValueWithExplicitNull is used to distinguish {} from
-// {"result":null} by anyMessage's JSON unmarshaler.
-type anyValueWithExplicitNull struct {
-	null  bool // JSON "null"
-	value interface{}
-}
-
-func (v anyValueWithExplicitNull) MarshalJSON() ([]byte, error) {
-	return json.Marshal(v.value)
+// invalidBatchElement returns the error of the first element of batch
+// that is not a valid request or response, or nil if there is none.
+func invalidBatchElement(batch []*anyMessage) error {
+	for _, m := range batch {
+		if m.invalid != nil {
+			return m.invalid
+		}
+	}
+	return nil
 }
 
-func (v *anyValueWithExplicitNull) UnmarshalJSON(data []byte) error {
-	data = bytes.TrimSpace(data)
-	if string(data) == "null" {
-		*v = anyValueWithExplicitNull{null: true}
-		return nil
+// withExplicitNullResult sets resp.Result to JSON null if resp has
+// neither a result nor an error, and returns resp.
+func withExplicitNullResult(resp *Response) *Response {
+	if resp.Error == nil && resp.Result == nil {
+		resp.Result = &jsonNull
 	}
-	*v = anyValueWithExplicitNull{}
-	return json.Unmarshal(data, &v.value)
+	return resp
 }
@@ -2,7 +2,9 @@ package jsonrpc2
 
 import (
 	"encoding/json"
+	"strconv"
 	"sync"
+	"time"
 )
 
 // Logger interface implements one method - Printf.
@@ -107,3 +109,152 @@ func SetLogger(logger Logger) ConnOpt {
 		c.logger = logger
 	}
 }
+
+// DefaultCancelMethod is the method of the notification used by
+// PropagateCancellation if no method is given. It is the method used by
+// the Language Server Protocol.
+const DefaultCancelMethod = "$/cancelRequest"
+
+// PropagateCancellation causes the cancellation of calls to be
+// propagated between peers, using notifications with the given method
+// and params of the form {"id": <request id>}. If method is empty,
+// DefaultCancelMethod is used.
+//
+// When the context passed to (*Conn).Call or (*Waiter).Wait is done
+// before the response arrives, the call is no longer awaited and the
+// notification is sent to the peer. When such a notification is
+// received, the context of the handler of the matching request is
+// canceled. The notifications are consumed by the Conn and are not
+// passed to the Handler.
+func PropagateCancellation(method string) ConnOpt {
+	if method == "" {
+		method = DefaultCancelMethod
+	}
+	return func(c *Conn) { c.cancelMethod = method }
+}
+
+// HandlerTimeout sets the timeout of the contexts passed to the handler
+// for requests and notifications with the given method. If method is
+// empty, the timeout applies to all methods that have no timeout of
+// their own.
+func HandlerTimeout(method string, timeout time.Duration) ConnOpt {
+	return func(c *Conn) {
+		if c.handlerTimeouts == nil {
+			c.handlerTimeouts = map[string]time.Duration{}
+		}
+		c.handlerTimeouts[method] = timeout
+	}
+}
+
+// TolerateMalformedMessages causes the Conn to reply to messages that
+// it cannot decode with a CodeParseError or CodeInvalidRequest error
+// (with a null ID), and to keep reading messages, instead of closing
+// the connection. Likewise, the elements of a batch of requests that
+// are not valid requests are answered with such an error in the batch
+// of responses, and the other requests of the batch are handled.
+//
+// This requires an ObjectStream that can skip a malformed message and
+// reports it with a *DecodeError, like the streams created by
+// NewBufferedStream with VarintObjectCodec or VSCodeObjectCodec. Other
+// read errors still close the connection.
+func TolerateMalformedMessages() ConnOpt {
+	return func(c *Conn) { c.tolerateMalformed = true }
+}
+
+// MatchResponseIDs causes the Conn to match responses with the calls
+// they answer by comparing the normalized IDs of the requests and of
+// the responses, as returned by normalize, instead of the IDs
+// themselves. This helps with peers that don't echo request IDs
+// exactly. See NormalizeNumericIDs for a common normalization.
+func MatchResponseIDs(normalize func(ID) ID) ConnOpt {
+	return func(c *Conn) { c.normalizeID = normalize }
+}
+
+// NormalizeNumericIDs is an ID normalization for MatchResponseIDs that
+// treats string IDs holding an integer like the numeric ID with that
+// value, so that a response with the ID "5" answers a request with the
+// ID 5, and vice versa.
+func NormalizeNumericIDs(id ID) ID {
+	if !id.IsString {
+		return id
+	}
+	if n, err := strconv.ParseUint(id.Str, 10, 64); err == nil {
+		return ID{Num: n}
+	}
+	if n, err := strconv.ParseInt(id.Str, 10, 64); err == nil {
+		return ID{Number: json.Number(strconv.FormatInt(n, 10))}
+	}
+	return id
+}
+
+// StringIDs causes the Conn to send the IDs of its requests as JSON
+// strings, including the IDs given with PickID. Numeric IDs are sent as
+// strings holding the number, so that peers that represent JSON
+// numbers with floating-point values (like JavaScript) never lose
+// precision.
+func StringIDs() ConnOpt {
+	return func(c *Conn) { c.stringIDs = true }
+}
+
+// GenerateIDs causes the Conn to use gen to assign IDs to its requests
+// instead of a sequence of numbers starting at 0. IDs given with PickID
+// are still used as is.
+func GenerateIDs(gen IDGenerator) ConnOpt {
+	return func(c *Conn) { c.generateID = gen }
+}
+
+// Keepalive causes the Conn to send a request with the given method
+// and no params to the peer every interval, and to close with
+// ErrKeepaliveTimeout as the cause (see (*Conn).Err) if it is not
+// answered within timeout. Any response, including an error response,
+// keeps the connection alive.
+//
+// Requests with the given method received from the peer are answered
+// by the Conn itself with a null result, and are not passed to the
+// Handler, so both peers may use Keepalive.
+//
+// If interval is zero or negative, the Conn sends no requests, but
+// still answers those of the peer. If timeout is zero or negative, each
+// request must be answered within interval, before the next one is
+// sent.
+func Keepalive(method string, interval, timeout time.Duration) ConnOpt {
+	return func(c *Conn) {
+		if timeout <= 0 {
+			timeout = interval
+		}
+		c.keepaliveMethod = method
+		c.keepaliveInterval = interval
+		c.keepaliveTimeout = timeout
+	}
+}
+
+// IdleTimeout causes the Conn to close with ErrIdleTimeout as the cause
+// (see (*Conn).Err) once it did not send nor receive any message for
+// the given duration, even if calls are awaiting their responses or
+// handlers are running.
+func IdleTimeout(d time.Duration) ConnOpt {
+	return func(c *Conn) { c.idleTimeout = d }
+}
+
+// OutboundQueue causes the Conn to write messages from a dedicated
+// goroutine, which takes them from a queue holding up to size requests
+// and notifications, and up to size responses. Responses are written
+// first. The messages in the queue are written together, with a single
+// flush if the stream implements ObjectsWriter, which reduces the cost
+// of sending many small messages.
+//
+// Call, Notify, Reply and the like return once the message is queued,
+// waiting for room in the queue until their context is done; the
+// context doesn't interrupt the writes themselves. If a message can't
+// be written, the Conn is closed with the write error as the cause
+// (see (*Conn).Err). Shutdown waits for the queued messages to be
+// written, while Close drops them.
+func OutboundQueue(size int) ConnOpt {
+	return func(c *Conn) { c.outbound = newOutboundQueue(size) }
+}
+
+// readAfter causes the Conn to start reading messages from its stream
+// once start is closed, so that no handler runs before.
+func readAfter(start <-chan struct{}) ConnOpt {
+	return func(c *Conn) { c.readStart = start }
+}
@@ -3,10 +3,15 @@ package jsonrpc2_test
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/sourcegraph/jsonrpc2"
 )
@@ -128,3 +133,425 @@ func TestLogMessages(t *testing.T) {
 		}
 	}
 }
+
+func TestPropagateCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rd, wr := io.Pipe()
+	defer rd.Close()
+	defer wr.Close()
+
+	buf := bufio.NewReader(rd)
+	logger := log.New(wr, "", log.Lmsgprefix)
+
+	started := make(chan struct{})
+	handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+		close(started)
+		<-ctx.Done()
+		// Reply anyway, the caller is expected to ignore the response.
+		respErr := &jsonrpc2.Error{Code: jsonrpc2.CodeInternalError, Message: ctx.Err().Error()}
+		if err := conn.ReplyWithError(context.Background(), req.ID, respErr); err != nil {
+			t.Error(err)
+		}
+	})
+
+	a, b := net.Pipe()
+	connA := jsonrpc2.NewConn(
+		ctx,
+		jsonrpc2.NewBufferedStream(a, jsonrpc2.VSCodeObjectCodec{}),
+		noopHandler{},
+		jsonrpc2.PropagateCancellation(""),
+		jsonrpc2.SetLogger(logger),
+	)
+	connB := jsonrpc2.NewConn(
+		ctx,
+		jsonrpc2.NewBufferedStream(b, jsonrpc2.VSCodeObjectCodec{}),
+		jsonrpc2.AsyncHandler(handler),
+		jsonrpc2.PropagateCancellation(""),
+	)
+	defer connA.Close()
+	defer connB.Close()
+
+	callCtx, cancelCall := context.WithCancel(ctx)
+	go func() {
+		<-started
+		cancelCall()
+	}()
+	w, err := connA.DispatchCall(ctx, "m", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Wait(callCtx, nil); err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+
+	// The handler's context is canceled, and its response no longer
+	// matches a pending call.
+	want := "jsonrpc2: ignoring response #0 with no corresponding request\n"
+	got, err := buf.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// Waiting for the canceled call again doesn't block.
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := w.Wait(waitCtx, nil); err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestTolerateMalformedMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu    sync.Mutex
+		sends int // the error responses with a null ID sent
+	)
+	a, b := net.Pipe()
+	defer a.Close()
+	conn := jsonrpc2.NewConn(
+		ctx,
+		jsonrpc2.NewBufferedStream(b, jsonrpc2.VSCodeObjectCodec{}),
+		&dummyHandler{t},
+		jsonrpc2.TolerateMalformedMessages(),
+		jsonrpc2.SetLogger(log.New(io.Discard, "", 0)),
+		jsonrpc2.OnSend(func(req *jsonrpc2.Request, resp *jsonrpc2.Response) {
+			if resp != nil && resp.ID.IsNull {
+				mu.Lock()
+				sends++
+				mu.Unlock()
+			}
+		}),
+	)
+	defer conn.Close()
+
+	codec := jsonrpc2.VSCodeObjectCodec{}
+	r := bufio.NewReader(a)
+	for _, test := range []struct {
+		send, want string
+	}{
+		{
+			send: `{"jsonrpc":"2.0","id":1,"method":`,
+			want: `{"id":null,"error":{"code":-32700,"message":"Parse error"},"jsonrpc":"2.0"}`,
+		},
+		{
+			send: `{"jsonrpc":"2.0","id":1}`,
+			want: `{"id":null,"error":{"code":-32600,"message":"Invalid Request"},"jsonrpc":"2.0"}`,
+		},
+		{
+			send: `[]`,
+			want: `{"id":null,"error":{"code":-32600,"message":"Invalid Request"},"jsonrpc":"2.0"}`,
+		},
+		{
+			// The valid requests of a batch are handled.
+			send: `[1,{"jsonrpc":"2.0","id":2,"method":"m"},{"jsonrpc":"2.0","id":3}]`,
+			want: `[{"id":null,"error":{"code":-32600,"message":"Invalid Request"},"jsonrpc":"2.0"},{"id":null,"error":{"code":-32600,"message":"Invalid Request"},"jsonrpc":"2.0"},{"id":2,"result":null,"jsonrpc":"2.0"}]`,
+		},
+		{
+			send: `[[1],{"jsonrpc":"2.0","method":"n"}]`,
+			want: `[{"id":null,"error":{"code":-32600,"message":"Invalid Request"},"jsonrpc":"2.0"}]`,
+		},
+		{
+			// The connection is still usable.
+			send: `{"jsonrpc":"2.0","id":1,"method":"m"}`,
+			want: `{"id":1,"result":null,"jsonrpc":"2.0"}`,
+		},
+	} {
+		go func(send string) {
+			// Write the frame by hand, since the codec can't marshal
+			// invalid JSON.
+			if _, err := fmt.Fprintf(a, "Content-Length: %d\r\n\r\n%s", len(send), send); err != nil {
+				t.Error(err)
+			}
+		}(test.send)
+
+		var got json.RawMessage
+		if err := codec.ReadObject(r, &got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got %s, want %s", test.send, got, test.want)
+		}
+	}
+
+	// The responses to malformed messages go through the OnSend hooks.
+	mu.Lock()
+	defer mu.Unlock()
+	if want := 6; sends != want {
+		t.Errorf("got %d responses in OnSend, want %d", sends, want)
+	}
+}
+
+func TestMatchResponseIDs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := net.Pipe()
+	defer a.Close()
+	conn := jsonrpc2.NewConn(
+		ctx,
+		jsonrpc2.NewPlainObjectStream(b),
+		noopHandler{},
+		jsonrpc2.MatchResponseIDs(jsonrpc2.NormalizeNumericIDs),
+	)
+	defer conn.Close()
+
+	// The peer echoes the numeric IDs of requests as strings.
+	go func() {
+		dec := json.NewDecoder(a)
+		for i := 0; i < 2; i++ {
+			var req jsonrpc2.Request
+			if err := dec.Decode(&req); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := fmt.Fprintf(a, `{"jsonrpc":"2.0","id":"%s","result":%q}`, req.ID, req.Method); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for _, id := range []jsonrpc2.ID{{}, {Number: "-1"}} {
+		var got string
+		if err := conn.Call(ctx, "m", nil, &got, jsonrpc2.PickID(id)); err != nil {
+			t.Fatal(err)
+		}
+		if got != "m" {
+			t.Errorf("got result %q, want %q", got, "m")
+		}
+	}
+}
+
+func TestNormalizeNumericIDs(t *testing.T) {
+	tests := map[jsonrpc2.ID]jsonrpc2.ID{
+		{Num: 5}:                     {Num: 5},
+		{Str: "5", IsString: true}:   {Num: 5},
+		{Str: "-5", IsString: true}:  {Number: "-5"},
+		{Str: "a", IsString: true}:   {Str: "a", IsString: true},
+		{Str: "1.5", IsString: true}: {Str: "1.5", IsString: true},
+		{Number: "-5"}:               {Number: "-5"},
+		{IsNull: true}:               {IsNull: true},
+	}
+	for id, want := range tests {
+		if got := jsonrpc2.NormalizeNumericIDs(id); got != want {
+			t.Errorf("%s: got %+v, want %+v", id, got, want)
+		}
+	}
+}
+
+func TestStringIDs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := net.Pipe()
+	defer a.Close()
+	conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(b), noopHandler{}, jsonrpc2.StringIDs())
+	defer conn.Close()
+
+	go func() {
+		_, _ = conn.DispatchCall(ctx, "m", nil)
+		_, _ = conn.DispatchCall(ctx, "m", nil, jsonrpc2.PickID(jsonrpc2.ID{Num: 1<<53 + 1}))
+	}()
+
+	dec := json.NewDecoder(a)
+	for _, want := range []string{`"0"`, `"9007199254740993"`} {
+		var req struct{ ID json.RawMessage }
+		if err := dec.Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if string(req.ID) != want {
+			t.Errorf("got ID %s, want %s", req.ID, want)
+		}
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	t.Run("answered", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			t.Errorf("handler got request %q", req.Method)
+		})
+		a, b := net.Pipe()
+		keepalive := jsonrpc2.Keepalive("$/ping", 5*time.Millisecond, time.Second)
+		connA := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(a), handler, keepalive)
+		connB := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(b), handler, keepalive)
+		defer connA.Close()
+		defer connB.Close()
+
+		select {
+		case <-connA.DisconnectNotify():
+			t.Fatalf("connection closed: %v", connA.Err())
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("zero interval and timeout", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			t.Errorf("handler got request %q", req.Method)
+		})
+		a, b := net.Pipe()
+		// connA only answers, and connB's requests must be answered
+		// within the interval.
+		connA := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(a), handler, jsonrpc2.Keepalive("$/ping", 0, 0))
+		connB := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(b), handler, jsonrpc2.Keepalive("$/ping", 20*time.Millisecond, 0))
+		defer connA.Close()
+		defer connB.Close()
+
+		select {
+		case <-connB.DisconnectNotify():
+			t.Fatalf("connection closed: %v", connB.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("unanswered", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		a, b := net.Pipe()
+		go func() { _, _ = io.Copy(io.Discard, b) }()
+		conn := jsonrpc2.NewConn(
+			ctx,
+			jsonrpc2.NewPlainObjectStream(a),
+			noopHandler{},
+			jsonrpc2.Keepalive("$/ping", 5*time.Millisecond, 10*time.Millisecond),
+			jsonrpc2.SetLogger(log.New(io.Discard, "", 0)),
+		)
+		<-conn.DisconnectNotify()
+		if err := conn.Err(); !errors.Is(err, jsonrpc2.ErrKeepaliveTimeout) {
+			t.Errorf("got error %v, want %v", err, jsonrpc2.ErrKeepaliveTimeout)
+		}
+	})
+}
+
+func TestIdleTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := net.Pipe()
+	conn := jsonrpc2.NewConn(
+		ctx,
+		jsonrpc2.NewPlainObjectStream(a),
+		noopHandler{},
+		jsonrpc2.IdleTimeout(100*time.Millisecond),
+		jsonrpc2.SetLogger(log.New(io.Discard, "", 0)),
+	)
+	peer := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(b), noopHandler{})
+	defer peer.Close()
+
+	// Messages keep the connection open.
+	for i := 0; i < 10; i++ {
+		if err := peer.Notify(ctx, "m", nil); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case <-conn.DisconnectNotify():
+		t.Fatalf("connection closed: %v", conn.Err())
+	default:
+	}
+
+	<-conn.DisconnectNotify()
+	if err := conn.Err(); !errors.Is(err, jsonrpc2.ErrIdleTimeout) {
+		t.Errorf("got error %v, want %v", err, jsonrpc2.ErrIdleTimeout)
+	}
+}
+
+func TestOutboundQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := &gatedStream{
+		incoming: make(chan string, 1),
+		writing:  make(chan struct{}),
+		gate:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	replied := make(chan struct{})
+	handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+		if err := conn.Reply(ctx, req.ID, "ok"); err != nil {
+			t.Error(err)
+		}
+		close(replied)
+	})
+	conn := jsonrpc2.NewConn(ctx, stream, handler, jsonrpc2.OutboundQueue(10))
+	defer conn.Close()
+
+	// The writer blocks on the first notification, while the others
+	// and a response are queued.
+	if err := conn.Notify(ctx, "n1", nil); err != nil {
+		t.Fatal(err)
+	}
+	<-stream.writing
+	for _, method := range []string{"n2", "n3"} {
+		if err := conn.Notify(ctx, method, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.incoming <- `{"jsonrpc":"2.0","id":1,"method":"m"}`
+	<-replied
+	close(stream.gate)
+
+	if err := conn.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"jsonrpc":"2.0","method":"n1"}`,
+		`{"id":1,"result":"ok","jsonrpc":"2.0"}`,
+		`{"jsonrpc":"2.0","method":"n2"}`,
+		`{"jsonrpc":"2.0","method":"n3"}`,
+	}
+	if fmt.Sprint(stream.written) != fmt.Sprint(want) {
+		t.Errorf("got written messages\n%v\nwant\n%v", stream.written, want)
+	}
+}
+
+// gatedStream is an ObjectStream whose first write blocks until gate
+// is closed. It records the written objects.
+type gatedStream struct {
+	incoming chan string
+	writing  chan struct{} // closed on the first write
+	gate     chan struct{}
+	written  []string
+	closed   chan struct{}
+}
+
+func (s *gatedStream) ReadObject(v interface{}) error {
+	select {
+	case m := <-s.incoming:
+		return json.Unmarshal([]byte(m), v)
+	case <-s.closed:
+		return io.EOF
+	}
+}
+
+func (s *gatedStream) WriteObject(obj interface{}) error {
+	if len(s.written) == 0 {
+		close(s.writing)
+		<-s.gate
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	s.written = append(s.written, string(b))
+	return nil
+}
+
+func (s *gatedStream) Close() error {
+	close(s.closed)
+	return nil
+}
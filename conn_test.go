@@ -3,10 +3,12 @@ package jsonrpc2_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -107,6 +109,28 @@ func TestConn_DispatchCall(t *testing.T) {
 	}
 }
 
+func TestConn_DispatchCall_idInUse(t *testing.T) {
+	ctx := context.Background()
+	connA, connB := Pipe(ctx, noopHandler{}, noopHandler{})
+	defer connA.Close()
+	defer connB.Close()
+
+	id := jsonrpc2.PickID(jsonrpc2.ID{Str: "x", IsString: true})
+	if _, err := connA.DispatchCall(ctx, "f", nil, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connA.DispatchCall(ctx, "f", nil, id); err == nil {
+		t.Fatal("got nil error, want error")
+	}
+
+	b := connA.NewBatch()
+	b.Call("f", nil, jsonrpc2.PickID(jsonrpc2.ID{Num: 1}))
+	b.Call("f", nil, jsonrpc2.PickID(jsonrpc2.ID{Num: 1}))
+	if err := b.Send(ctx); err == nil {
+		t.Fatal("got nil error, want error")
+	}
+}
+
 func TestConn_Notify(t *testing.T) {
 	for _, test := range paramsTests {
 		t.Run(fmt.Sprintf("%s", test.sendParams), func(t *testing.T) {
@@ -169,6 +193,65 @@ func TestConn_DisconnectNotify(t *testing.T) {
 	})
 }
 
+func TestConn_Err(t *testing.T) {
+	t.Run("Close", func(t *testing.T) {
+		connA, connB := Pipe(context.Background(), noopHandler{}, noopHandler{})
+		defer connB.Close()
+
+		if err := connA.Err(); err != nil {
+			t.Fatalf("got error %v before close, want nil", err)
+		}
+		if err := connA.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := connA.Err(), jsonrpc2.ErrClosed; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("peer hangup", func(t *testing.T) {
+		connA, connB := Pipe(context.Background(), noopHandler{}, noopHandler{})
+		defer connA.Close()
+
+		if err := connB.Close(); err != nil {
+			t.Fatal(err)
+		}
+		<-connA.DisconnectNotify()
+		err := connA.Err()
+		if !errors.Is(err, jsonrpc2.ErrClosed) || !errors.Is(err, io.EOF) {
+			t.Fatalf("got %v, want error wrapping %v and %v", err, jsonrpc2.ErrClosed, io.EOF)
+		}
+	})
+
+	t.Run("protocol error during Wait", func(t *testing.T) {
+		ctx := context.Background()
+
+		a, b := net.Pipe()
+		conn := jsonrpc2.NewConn(
+			ctx,
+			jsonrpc2.NewPlainObjectStream(b),
+			noopHandler{},
+			jsonrpc2.SetLogger(log.New(io.Discard, "", 0)),
+		)
+		defer conn.Close()
+
+		go func() {
+			// Read the request, and reply with invalid JSON.
+			var req jsonrpc2.Request
+			if err := json.NewDecoder(a).Decode(&req); err != nil {
+				t.Error(err)
+			}
+			a.Write([]byte("}"))
+		}()
+
+		err := conn.Call(ctx, "m", nil, nil)
+		var syntaxErr *json.SyntaxError
+		if !errors.Is(err, jsonrpc2.ErrClosed) || !errors.As(err, &syntaxErr) {
+			t.Fatalf("got %v, want error wrapping %v and a *json.SyntaxError", err, jsonrpc2.ErrClosed)
+		}
+	})
+}
+
 func TestConn_Close(t *testing.T) {
 	cases := []struct {
 		name string
@@ -243,6 +326,350 @@ func TestConn_Close(t *testing.T) {
 	}
 }
 
+func TestConn_Batch(t *testing.T) {
+	t.Run("inbound requests", func(t *testing.T) {
+		ctx := context.Background()
+
+		a, b := net.Pipe()
+		defer a.Close()
+		handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			if req.Notif {
+				return
+			}
+			if err := conn.Reply(ctx, req.ID, "reply to "+req.Method); err != nil {
+				t.Error(err)
+			}
+		})
+		conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(b), jsonrpc2.AsyncHandler(handler))
+		defer conn.Close()
+
+		go func() {
+			batch := `[{"jsonrpc":"2.0","id":1,"method":"a"},{"jsonrpc":"2.0","method":"n"},{"jsonrpc":"2.0","id":2,"method":"b"}]`
+			if _, err := a.Write([]byte(batch)); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		var resps []jsonrpc2.Response
+		if err := json.NewDecoder(a).Decode(&resps); err != nil {
+			t.Fatal(err)
+		}
+		got := map[jsonrpc2.ID]string{}
+		for _, resp := range resps {
+			got[resp.ID] = string(*resp.Result)
+		}
+		want := map[jsonrpc2.ID]string{
+			{Num: 1}: `"reply to a"`,
+			{Num: 2}: `"reply to b"`,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("inbound invalid element", func(t *testing.T) {
+		ctx := context.Background()
+
+		a, b := net.Pipe()
+		defer a.Close()
+		conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(b), noopHandler{}, jsonrpc2.SetLogger(log.New(io.Discard, "", 0)))
+		defer conn.Close()
+
+		go func() {
+			batch := `[1,{"jsonrpc":"2.0","id":1,"method":"m"}]`
+			if _, err := a.Write([]byte(batch)); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		// Without TolerateMalformedMessages, the connection is closed.
+		<-conn.DisconnectNotify()
+		if err := conn.Err(); err == nil || err == jsonrpc2.ErrClosed {
+			t.Errorf("got Err %v, want the decoding error", err)
+		}
+	})
+
+	t.Run("inbound responses", func(t *testing.T) {
+		ctx := context.Background()
+
+		a, b := net.Pipe()
+		defer a.Close()
+		conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(b), noopHandler{})
+		defer conn.Close()
+
+		dec := json.NewDecoder(a)
+		var waiters []jsonrpc2.Waiter
+		var reqs []jsonrpc2.Request
+		for _, method := range []string{"a", "b"} {
+			done := make(chan struct{})
+			go func(method string) {
+				defer close(done)
+				w, err := conn.DispatchCall(ctx, method, nil)
+				if err != nil {
+					t.Error(err)
+				}
+				waiters = append(waiters, w)
+			}(method)
+			var req jsonrpc2.Request
+			if err := dec.Decode(&req); err != nil {
+				t.Fatal(err)
+			}
+			reqs = append(reqs, req)
+			<-done
+		}
+
+		// Reply to both requests in a single batch, in reverse order.
+		batch := fmt.Sprintf(`[{"jsonrpc":"2.0","id":%s,"result":"b"},{"jsonrpc":"2.0","id":%s,"result":"a"}]`, reqs[1].ID, reqs[0].ID)
+		go func() {
+			if _, err := a.Write([]byte(batch)); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		for i, want := range []string{"a", "b"} {
+			var got string
+			if err := waiters[i].Wait(ctx, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("got result %q, want %q", got, want)
+			}
+		}
+	})
+}
+
+func TestConn_sendContext(t *testing.T) {
+	t.Run("done", func(t *testing.T) {
+		ctx := context.Background()
+		connA, connB := Pipe(ctx, noopHandler{}, noopHandler{})
+		defer connA.Close()
+		defer connB.Close()
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := connA.Notify(canceled, "m", nil); err != context.Canceled {
+			t.Fatalf("got error %v, want %v", err, context.Canceled)
+		}
+		if err := connA.Err(); err != nil {
+			t.Fatalf("got Err %v, want nil", err)
+		}
+	})
+
+	t.Run("waiting for another write", func(t *testing.T) {
+		ctx := context.Background()
+		a, b := net.Pipe()
+		defer b.Close()
+		// The stream doesn't support deadlines.
+		stream := jsonrpc2.NewPlainObjectStream(struct{ io.ReadWriteCloser }{a})
+		conn := jsonrpc2.NewConn(ctx, stream, noopHandler{}, jsonrpc2.SetLogger(log.New(io.Discard, "", 0)))
+		defer conn.Close()
+
+		go func() { _ = conn.Notify(ctx, "blocked", nil) }()
+		// Wait for the first write to start, and block it.
+		if _, err := b.Read(make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if err := conn.Notify(timeout, "m", nil); err != context.DeadlineExceeded {
+			t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+		}
+		if err := conn.Err(); err != nil {
+			t.Fatalf("got Err %v, want nil", err)
+		}
+	})
+
+	t.Run("write without deadlines", func(t *testing.T) {
+		ctx := context.Background()
+		r, rw := io.Pipe()
+		defer rw.Close()
+		_, w := io.Pipe()
+		stream := jsonrpc2.NewBufferedStream(struct {
+			io.Reader
+			io.WriteCloser
+		}{r, w}, jsonrpc2.VSCodeObjectCodec{})
+		if _, ok := stream.(jsonrpc2.WriteDeadlineSetter); ok {
+			t.Fatal("stream of an io.Pipe implements WriteDeadlineSetter")
+		}
+		conn := jsonrpc2.NewConn(ctx, stream, noopHandler{}, jsonrpc2.SetLogger(log.New(io.Discard, "", 0)))
+
+		// Nothing reads the pipe, so the write can't complete, and
+		// it can't be interrupted.
+		sent := make(chan error, 1)
+		go func() {
+			timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			sent <- conn.Notify(timeout, "m", nil)
+		}()
+		select {
+		case err := <-sent:
+			t.Fatalf("Notify returned %v while writing", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		conn.Close()
+		<-sent
+	})
+
+	t.Run("interrupted write", func(t *testing.T) {
+		ctx := context.Background()
+		a, b := net.Pipe()
+		defer b.Close()
+		conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(a), noopHandler{}, jsonrpc2.SetLogger(log.New(io.Discard, "", 0)))
+		defer conn.Close()
+
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if err := conn.Notify(timeout, "m", nil); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+		}
+		// Part of the message may have been written, so the
+		// connection is closed.
+		<-conn.DisconnectNotify()
+		if err := conn.Err(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got Err %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestConn_Shutdown(t *testing.T) {
+	t.Run("drains handlers", func(t *testing.T) {
+		ctx := context.Background()
+
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			if req.Method == "slow" {
+				started <- struct{}{}
+				<-release
+			}
+			if err := conn.Reply(ctx, req.ID, req.Method); err != nil {
+				t.Error(err)
+			}
+		})
+		connA, connB := Pipe(ctx, noopHandler{}, jsonrpc2.AsyncHandler(handler))
+		defer connA.Close()
+		defer connB.Close()
+
+		slow, err := connA.DispatchCall(ctx, "slow", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-started
+
+		shutdown := make(chan error)
+		go func() { shutdown <- connB.Shutdown(ctx) }()
+
+		// Requests that arrive during the shutdown are rejected.
+		for {
+			err := connA.Call(ctx, "fast", nil, nil)
+			if err == nil {
+				continue // received before the shutdown started
+			}
+			if _, ok := err.(*jsonrpc2.Error); !ok {
+				t.Fatalf("got error %v, want *jsonrpc2.Error", err)
+			}
+			break
+		}
+		select {
+		case err := <-shutdown:
+			t.Fatalf("Shutdown returned %v before the handler returned", err)
+		default:
+		}
+
+		close(release)
+		var got string
+		if err := slow.Wait(ctx, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != "slow" {
+			t.Errorf("got result %q, want %q", got, "slow")
+		}
+		if err := <-shutdown; err != nil {
+			t.Fatal(err)
+		}
+		<-connB.DisconnectNotify()
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		ctx := context.Background()
+
+		connA, connB := Pipe(ctx, noopHandler{}, noopHandler{})
+		defer connA.Close()
+		defer connB.Close()
+
+		// This call is never replied to.
+		if _, err := connA.DispatchCall(ctx, "m", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		if err := connA.Shutdown(shutdownCtx); err != context.DeadlineExceeded {
+			t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+		}
+		<-connA.DisconnectNotify()
+	})
+
+	t.Run("abandoned call", func(t *testing.T) {
+		ctx := context.Background()
+
+		connA, connB := Pipe(ctx, noopHandler{}, noopHandler{})
+		defer connA.Close()
+		defer connB.Close()
+
+		// This call is never replied to, and its caller gives up.
+		waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if err := connA.Call(waitCtx, "m", nil, nil); err != context.DeadlineExceeded {
+			t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if err := connA.Shutdown(shutdownCtx); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestWaiter_Wait_again(t *testing.T) {
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+		<-release
+		if err := conn.Reply(ctx, req.ID, "result"); err != nil {
+			t.Error(err)
+		}
+	})
+	connA, connB := Pipe(ctx, noopHandler{}, jsonrpc2.AsyncHandler(handler))
+	defer connA.Close()
+	defer connB.Close()
+
+	w, err := connA.DispatchCall(ctx, "m", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := w.Wait(waitCtx, nil); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The abandoned call still receives its response.
+	close(release)
+	waitCtx, cancel = context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	var got string
+	if err := w.Wait(waitCtx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != "result" {
+		t.Errorf("got result %q, want %q", got, "result")
+	}
+}
+
 func testParams(t *testing.T, want *json.RawMessage, fn func(c *jsonrpc2.Conn) error) {
 	wg := &sync.WaitGroup{}
 	handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, r *jsonrpc2.Request) {
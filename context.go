@@ -0,0 +1,36 @@
+package jsonrpc2
+
+import "context"
+
+type contextKey int
+
+const (
+	connContextKey contextKey = iota
+	requestContextKey
+	handlerContextKey
+)
+
+// ConnFromContext returns the Conn that received the request being
+// handled with ctx, or nil if ctx is not the context of a handler.
+func ConnFromContext(ctx context.Context) *Conn {
+	c, _ := ctx.Value(connContextKey).(*Conn)
+	return c
+}
+
+// RequestFromContext returns the request being handled with ctx, or
+// nil if ctx is not the context of a handler.
+func RequestFromContext(ctx context.Context) *Request {
+	req, _ := ctx.Value(requestContextKey).(*Request)
+	return req
+}
+
+// RequestIDFromContext returns the ID of the request being handled
+// with ctx. It returns false if ctx is not the context of a handler, or
+// if the request is a notification.
+func RequestIDFromContext(ctx context.Context) (ID, bool) {
+	req := RequestFromContext(ctx)
+	if req == nil || req.Notif {
+		return ID{}, false
+	}
+	return req.ID, true
+}
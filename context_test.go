@@ -0,0 +1,134 @@
+package jsonrpc2_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestRequestContext(t *testing.T) {
+	t.Run("values", func(t *testing.T) {
+		ctx := context.Background()
+
+		handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			if got := jsonrpc2.ConnFromContext(ctx); got != conn {
+				t.Errorf("got conn %p, want %p", got, conn)
+			}
+			if got := jsonrpc2.RequestFromContext(ctx); got != req {
+				t.Errorf("got request %+v, want %+v", got, req)
+			}
+			id, ok := jsonrpc2.RequestIDFromContext(ctx)
+			if !ok || id != req.ID {
+				t.Errorf("got ID %s (%v), want %s", id, ok, req.ID)
+			}
+			if err := conn.Reply(ctx, req.ID, nil); err != nil {
+				t.Error(err)
+			}
+		})
+		connA, connB := Pipe(ctx, noopHandler{}, handler)
+		defer connA.Close()
+		defer connB.Close()
+
+		if err := connA.Call(ctx, "m", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := jsonrpc2.RequestFromContext(ctx); got != nil {
+			t.Errorf("got request %+v, want nil", got)
+		}
+	})
+
+	t.Run("independent cancellation", func(t *testing.T) {
+		ctx := context.Background()
+
+		handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			var err error
+			switch req.Method {
+			case "slow":
+				select {
+				case <-ctx.Done():
+					err = ctx.Err()
+				case <-time.After(time.Second):
+				}
+			case "fast":
+			}
+			if err != nil {
+				err = conn.ReplyWithError(ctx, req.ID, &jsonrpc2.Error{Message: err.Error()})
+			} else {
+				err = conn.Reply(ctx, req.ID, req.Method)
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		})
+		a, b := inMemoryPeerConns()
+		connA := jsonrpc2.NewConn(ctx, jsonrpc2.NewBufferedStream(a, jsonrpc2.VSCodeObjectCodec{}), noopHandler{})
+		connB := jsonrpc2.NewConn(
+			ctx,
+			jsonrpc2.NewBufferedStream(b, jsonrpc2.VSCodeObjectCodec{}),
+			jsonrpc2.AsyncHandler(handler),
+			jsonrpc2.HandlerTimeout("slow", 10*time.Millisecond),
+		)
+		defer connA.Close()
+		defer connB.Close()
+
+		slow, err := connA.DispatchCall(ctx, "slow", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if err := connA.Call(ctx, "fast", nil, &got); err != nil {
+			t.Fatal(err)
+		}
+		if want := "fast"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		err = slow.Wait(ctx, nil)
+		respErr, ok := err.(*jsonrpc2.Error)
+		if want := context.DeadlineExceeded.Error(); !ok || respErr.Message != want {
+			t.Errorf("got error %v, want %q", err, want)
+		}
+	})
+
+	t.Run("released", func(t *testing.T) {
+		ctx := context.Background()
+
+		ctxs := make(chan context.Context, 2)
+		handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			ctxs <- ctx
+			if !req.Notif {
+				if err := conn.Reply(ctx, req.ID, nil); err != nil {
+					t.Error(err)
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				t.Errorf("%s: got context error %v before Handle returned", req.Method, err)
+			}
+		})
+		a, b := inMemoryPeerConns()
+		connA := jsonrpc2.NewConn(ctx, jsonrpc2.NewBufferedStream(a, jsonrpc2.VSCodeObjectCodec{}), noopHandler{})
+		connB := jsonrpc2.NewConn(
+			ctx,
+			jsonrpc2.NewBufferedStream(b, jsonrpc2.VSCodeObjectCodec{}),
+			jsonrpc2.AsyncHandler(handler),
+		)
+		defer connA.Close()
+		defer connB.Close()
+
+		if err := connA.Call(ctx, "m", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := connA.Notify(ctx, "n", nil); err != nil {
+			t.Fatal(err)
+		}
+		for _, method := range []string{"m", "n"} {
+			select {
+			case <-(<-ctxs).Done():
+			case <-time.After(time.Second):
+				t.Errorf("%s: context not released once handled", method)
+			}
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package jsonrpc2
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// messageFields holds the top-level fields of a JSON-RPC message
+// object, as raw JSON. A nil field is missing from the object.
+//
+// Messages are decoded in a single pass: readMessageFields walks the
+// top-level keys of the object, and keeps each value as raw JSON, so
+// that large params are only scanned once and never decoded into
+// generic values.
+type messageFields struct {
+	id, method, params, meta json.RawMessage
+	result, error            json.RawMessage
+	extra                    []RequestField
+}
+
+// errNotObject is returned by readMessageFields if the next JSON value
+// is not an object.
+var errNotObject = errors.New("jsonrpc2: message must be a JSON object")
+
+// readMessageFields reads the next JSON object from dec into f. If the
+// next JSON value is not an object, it is skipped and errNotObject is
+// returned.
+func readMessageFields(dec *json.Decoder, f *messageFields) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == json.Delim('[') {
+		if err := skipArray(dec); err != nil {
+			return err
+		}
+	}
+	if tok != json.Delim('{') {
+		return errNotObject
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name, _ := tok.(string) // object keys are strings
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		switch name {
+		case "id":
+			f.id = value
+		case "method":
+			f.method = value
+		case "params":
+			f.params = value
+		case "meta":
+			f.meta = value
+		case "result":
+			f.result = value
+		case "error":
+			f.error = value
+		case "jsonrpc":
+			// The jsonrpc field is implied.
+		default:
+			f.setExtra(name, value)
+		}
+	}
+	_, err = dec.Token() // '}'
+	return err
+}
+
+// skipArray skips the rest of the JSON array whose opening bracket was
+// just read from dec.
+func skipArray(dec *json.Decoder) error {
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('['), json.Delim('{'):
+			depth++
+		case json.Delim(']'), json.Delim('}'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// setExtra sets the extra field name, replacing its previous value if
+// the object has duplicate keys (the last one wins, as with
+// json.Unmarshal).
+func (f *messageFields) setExtra(name string, value json.RawMessage) {
+	for i := range f.extra {
+		if f.extra[i].Name == name {
+			f.extra[i].Value = value
+			return
+		}
+	}
+	f.extra = append(f.extra, RequestField{Name: name, Value: value})
+}
+
+// messageType reports whether f holds a request (rather than a
+// response), or returns an error if it holds neither or both.
+func (f *messageFields) messageType() (isRequest bool, err error) {
+	isRequest, isResponse := f.isRequest(), f.isResponse()
+	if isRequest == isResponse {
+		return false, errors.New("jsonrpc2: unable to determine message type (request or response)")
+	}
+	return isRequest, nil
+}
+
+func (f *messageFields) isRequest() bool {
+	return f.method != nil && !isJSONNull(f.method)
+}
+
+func (f *messageFields) isResponse() bool {
+	return f.result != nil || (f.error != nil && !isJSONNull(f.error))
+}
+
+// decodeRequest stores the request held by f in r.
+func (f *messageFields) decodeRequest(r *Request) error {
+	*r = Request{}
+	if len(f.method) == 0 || f.method[0] != '"' {
+		return errors.New("missing method field")
+	}
+	if err := json.Unmarshal(f.method, &r.Method); err != nil {
+		return err
+	}
+	r.Params = explicitNull(f.params)
+	r.Meta = explicitNull(f.meta)
+	if f.id == nil {
+		r.Notif = true
+	} else if err := r.ID.UnmarshalJSON(f.id); err != nil {
+		return err
+	}
+	r.ExtraFields = f.extra
+	return nil
+}
+
+// decodeResponse stores the response held by f in r.
+func (f *messageFields) decodeResponse(r *Response) error {
+	*r = Response{}
+	if f.id != nil {
+		if err := r.ID.UnmarshalJSON(f.id); err != nil {
+			return err
+		}
+	}
+	r.Result = explicitNull(f.result)
+	if f.error != nil {
+		if err := json.Unmarshal(f.error, &r.Error); err != nil {
+			return err
+		}
+	}
+	if f.meta != nil && !isJSONNull(f.meta) {
+		r.Meta = &f.meta
+	}
+	return nil
+}
+
+// explicitNull returns a pointer to the raw field value v, or nil if
+// the field is missing. A JSON null value is returned as &jsonNull, to
+// tell it apart from a missing field.
+func explicitNull(v json.RawMessage) *json.RawMessage {
+	switch {
+	case v == nil:
+		return nil
+	case isJSONNull(v):
+		return &jsonNull
+	}
+	return &v
+}
+
+func isJSONNull(data json.RawMessage) bool {
+	return string(data) == "null"
+}
@@ -1,5 +1,5 @@
 module github.com/sourcegraph/jsonrpc2
 
-go 1.12
+go 1.18
 
 require github.com/gorilla/websocket v1.4.1
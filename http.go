@@ -0,0 +1,312 @@
+package jsonrpc2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// HTTPHandler returns an http.Handler that serves JSON-RPC over HTTP.
+// Each POST request carries a JSON-RPC request or batch in its body,
+// which is handled by h on a Conn created with opts for the duration
+// of the HTTP request. The response is written to the body of the HTTP
+// response, or the HTTP status is 204 No Content if the body only has
+// notifications.
+//
+// Since HTTP only carries responses back to the client, the Conn passed
+// to h can't send requests or notifications to the client.
+//
+// The response is written once the handlers of the requests are done,
+// which the Conn only knows for handlers that are synchronous or are
+// wrapped in one of the async handlers of this package (see
+// (*Conn).Shutdown). If h handles requests in the background in another
+// way, the HTTP status is 500 Internal Server Error.
+//
+// The body of a request may hold up to DefaultMaxHTTPBodySize bytes;
+// use MaxBodySize to change this limit. Larger requests get the HTTP
+// status 413 Request Entity Too Large.
+func HTTPHandler(h Handler, opts ...ConnOpt) *HTTPHandlerConfigurer {
+	return &HTTPHandlerConfigurer{h: h, opts: opts, maxBodySize: DefaultMaxHTTPBodySize}
+}
+
+// DefaultMaxHTTPBodySize is the maximum size in bytes of the body of
+// the requests served by HTTPHandler, and of the POST requests served
+// by SSEHandler, unless changed with MaxBodySize.
+const DefaultMaxHTTPBodySize = 10 << 20
+
+// HTTPHandlerConfigurer is an http.Handler created by HTTPHandler.
+type HTTPHandlerConfigurer struct {
+	h           Handler
+	opts        []ConnOpt
+	maxBodySize int64
+}
+
+// MaxBodySize sets the maximum size in bytes of the body of the HTTP
+// requests. The original handler `h` is returned.
+func (h *HTTPHandlerConfigurer) MaxBodySize(n int64) http.Handler {
+	h.maxBodySize = n
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *HTTPHandlerConfigurer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "jsonrpc2: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	if err != nil {
+		status := http.StatusBadRequest
+		if int64(len(body)) == h.maxBodySize {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, "jsonrpc2: reading request body: "+err.Error(), status)
+		return
+	}
+
+	var m anyMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		writeHTTPResponse(w, malformedResponse(err))
+		return
+	}
+	reqs := []*anyMessage{&m}
+	if m.batch != nil {
+		reqs = m.batch
+	}
+	wantResponse := false
+	for _, req := range reqs {
+		if req.invalid != nil {
+			// The Conn answers it with an error (see
+			// TolerateMalformedMessages).
+			wantResponse = true
+			continue
+		}
+		if req.request == nil {
+			http.Error(w, "jsonrpc2: request body must be a request or a batch of requests", http.StatusBadRequest)
+			return
+		}
+		wantResponse = wantResponse || !req.request.Notif
+	}
+
+	stream := &httpServerStream{
+		msg:      &m,
+		consumed: make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	opts := append(h.opts[:len(h.opts):len(h.opts)], TolerateMalformedMessages())
+	conn := NewConn(r.Context(), stream, h.h, opts...)
+	select {
+	case <-stream.consumed:
+	case <-conn.DisconnectNotify():
+	}
+	// Wait for the handlers (and so for their responses).
+	if err := conn.Shutdown(r.Context()); err != nil && !errors.Is(err, ErrClosed) {
+		return // the client went away
+	}
+
+	switch resp := stream.response(); {
+	case resp != nil:
+		writeHTTPResponse(w, resp)
+	case wantResponse:
+		http.Error(w, "jsonrpc2: no response to request", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeHTTPResponse(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "jsonrpc2: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// httpServerStream is the ObjectStream of the Conn that handles an
+// HTTP request. It reads the message of the request body, and then
+// blocks until the stream is closed. It holds the response written by
+// the Conn.
+type httpServerStream struct {
+	msg      *anyMessage
+	consumed chan struct{} // closed when the Conn reads past msg
+
+	mu        sync.Mutex
+	read      bool
+	resp      interface{}
+	closeOnce sync.Once
+
+	closed chan struct{}
+}
+
+// ReadObject implements ObjectStream.
+func (s *httpServerStream) ReadObject(v interface{}) error {
+	s.mu.Lock()
+	read := s.read
+	s.read = true
+	s.mu.Unlock()
+	if !read {
+		m, ok := v.(*anyMessage)
+		if !ok {
+			return fmt.Errorf("jsonrpc2: can't read into %T", v)
+		}
+		*m = *s.msg
+		return nil
+	}
+
+	// The Conn is done with msg: requests were handled, or handed off
+	// to background handlers.
+	close(s.consumed)
+	<-s.closed
+	return io.EOF
+}
+
+// WriteObject implements ObjectStream.
+func (s *httpServerStream) WriteObject(obj interface{}) error {
+	if m, ok := obj.(*anyMessage); ok && (m.request != nil || (len(m.batch) > 0 && m.batch[0].request != nil)) {
+		return errors.New("jsonrpc2: can't send requests to an HTTP client")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resp != nil {
+		return errors.New("jsonrpc2: response to HTTP request already sent")
+	}
+	s.resp = obj
+	return nil
+}
+
+func (s *httpServerStream) response() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resp
+}
+
+// Close implements ObjectStream.
+func (s *httpServerStream) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
+
+// HTTPClient is a JSON-RPC client that sends each request as an HTTP
+// POST request to URL, such as one served by HTTPHandler. Its zero
+// value (with URL set) is ready to use, and it is safe for concurrent
+// use. An HTTPClient must not be copied after first use.
+type HTTPClient struct {
+	// URL is the URL of the JSON-RPC server.
+	URL string
+
+	// Client is the HTTP client used to send the requests. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+
+	// Header holds the HTTP headers added to each request.
+	Header http.Header
+
+	seq uint64
+}
+
+var _ JSONRPC2 = (*HTTPClient)(nil)
+
+// Call sends a request to the server and waits for the response. If
+// the response is successful, its result is stored in result (a pointer
+// to a value that can be JSON-unmarshaled into); otherwise, a non-nil
+// error is returned.
+func (c *HTTPClient) Call(ctx context.Context, method string, params, result interface{}, opts ...CallOption) error {
+	req, err := newRequest(method, params, false, opts)
+	if err != nil {
+		return err
+	}
+	if req.ID == (ID{}) || req.ID == (ID{IsString: true}) {
+		seq := atomic.AddUint64(&c.seq, 1) - 1
+		if req.ID.IsString {
+			req.ID.Str = strconv.FormatUint(seq, 10)
+		} else {
+			req.ID.Num = seq
+		}
+	}
+
+	body, err := c.post(ctx, req)
+	if err != nil {
+		return err
+	}
+	var resp Response
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("jsonrpc2: invalid response: %w", err)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	if resp.Result == nil {
+		resp.Result = &jsonNull
+	}
+	return json.Unmarshal(*resp.Result, result)
+}
+
+// Notify sends a notification to the server.
+func (c *HTTPClient) Notify(ctx context.Context, method string, params interface{}, opts ...CallOption) error {
+	req, err := newRequest(method, params, true, opts)
+	if err != nil {
+		return err
+	}
+	_, err = c.post(ctx, req)
+	return err
+}
+
+// Close implements JSONRPC2. It closes the idle connections of Client,
+// if set. The idle connections of http.DefaultClient are left alone,
+// since it is shared with the rest of the program.
+func (c *HTTPClient) Close() error {
+	if c.Client != nil {
+		c.Client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// post sends v to the server and returns the body of the response.
+func (c *HTTPClient) post(ctx context.Context, v interface{}) ([]byte, error) {
+	b, err := marshalObject(v)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range c.Header {
+		httpReq.Header[k] = vs
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := c.client().Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK && httpResp.StatusCode != http.StatusNoContent {
+		return nil, fmt.Errorf("jsonrpc2: unexpected HTTP status %s", httpResp.Status)
+	}
+	return body, nil
+}
+
+func (c *HTTPClient) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,199 @@
+package jsonrpc2_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	notified := make(chan string, 2)
+	handler := jsonrpc2.HandlerWithError(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+		if req.Notif {
+			notified <- req.Method
+			return nil, nil
+		}
+		if req.Method == "fail" {
+			return nil, &jsonrpc2.Error{Code: 123, Message: "failed"}
+		}
+		return req.Method, nil
+	})
+	srv := httptest.NewServer(jsonrpc2.HTTPHandler(jsonrpc2.AsyncHandler(handler)))
+	defer srv.Close()
+
+	tests := []struct {
+		method, body string
+		wantStatus   int
+		wantBody     string
+	}{
+		{
+			method:     http.MethodPost,
+			body:       `{"jsonrpc":"2.0","id":1,"method":"m"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"id":1,"result":"m","jsonrpc":"2.0"}`,
+		},
+		{
+			method:     http.MethodPost,
+			body:       `[{"jsonrpc":"2.0","id":1,"method":"a"},{"jsonrpc":"2.0","method":"n"},{"jsonrpc":"2.0","id":2,"method":"fail"}]`,
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"id":1,"result":"a","jsonrpc":"2.0"},{"id":2,"error":{"code":123,"message":"failed"},"jsonrpc":"2.0"}]`,
+		},
+		{
+			method:     http.MethodPost,
+			body:       `[1,{"jsonrpc":"2.0","id":1,"method":"a"}]`,
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"id":null,"error":{"code":-32600,"message":"Invalid Request"},"jsonrpc":"2.0"},{"id":1,"result":"a","jsonrpc":"2.0"}]`,
+		},
+		{
+			method:     http.MethodPost,
+			body:       `{"jsonrpc":"2.0","method":"n"}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			method:     http.MethodPost,
+			body:       `{"jsonrpc":"2.0",`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"id":null,"error":{"code":-32700,"message":"Parse error"},"jsonrpc":"2.0"}`,
+		},
+		{
+			method:     http.MethodPost,
+			body:       `{"jsonrpc":"2.0","id":1,"result":1}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, srv.URL, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got json.RawMessage
+		if test.wantBody != "" {
+			err = json.NewDecoder(resp.Body).Decode(&got)
+		}
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.wantStatus {
+			t.Errorf("%s %s: got status %d, want %d", test.method, test.body, resp.StatusCode, test.wantStatus)
+		}
+		if test.wantBody != "" && sortBatch(t, got) != sortBatch(t, json.RawMessage(test.wantBody)) {
+			t.Errorf("%s: got body %s, want %s", test.body, got, test.wantBody)
+		}
+	}
+	if got := len(notified); got != 2 {
+		t.Errorf("got %d notifications, want 2", got)
+	}
+}
+
+func TestHTTPHandler_MaxBodySize(t *testing.T) {
+	handler := jsonrpc2.HandlerWithError(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+		return req.Method, nil
+	})
+	srv := httptest.NewServer(jsonrpc2.HTTPHandler(handler).MaxBodySize(64))
+	defer srv.Close()
+
+	for body, wantStatus := range map[string]int{
+		`{"jsonrpc":"2.0","id":1,"method":"m"}`:                                            http.StatusOK,
+		`{"jsonrpc":"2.0","id":1,"method":"m","params":"` + strings.Repeat("x", 64) + `"}`: http.StatusRequestEntityTooLarge,
+	} {
+		resp, err := http.Post(srv.URL, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != wantStatus {
+			t.Errorf("%s: got status %d, want %d", body, resp.StatusCode, wantStatus)
+		}
+	}
+}
+
+// sortBatch returns the responses of the batch b in a deterministic
+// order, since the order of the responses of a batch is unspecified. It
+// returns other messages as is.
+func sortBatch(t *testing.T, b json.RawMessage) string {
+	if !strings.HasPrefix(string(b), "[") {
+		return string(b)
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []string
+	for _, m := range raw {
+		msgs = append(msgs, string(m))
+	}
+	sort.Strings(msgs)
+	return "[" + strings.Join(msgs, ",") + "]"
+}
+
+func TestHTTPClient(t *testing.T) {
+	gotHeader := make(chan string, 2)
+	notified := make(chan string, 1)
+	handler := jsonrpc2.HandlerWithError(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+		if req.Notif {
+			notified <- req.Method
+			return nil, nil
+		}
+		if req.Method == "fail" {
+			return nil, &jsonrpc2.Error{Code: 123, Message: "failed"}
+		}
+		var s string
+		if err := json.Unmarshal(*req.Params, &s); err != nil {
+			return nil, err
+		}
+		return req.Method + " " + s, nil
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader <- r.Header.Get("Authorization")
+		jsonrpc2.HTTPHandler(handler).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client := &jsonrpc2.HTTPClient{
+		URL:    srv.URL,
+		Client: srv.Client(),
+		Header: http.Header{"Authorization": {"Bearer x"}},
+	}
+	defer client.Close()
+
+	var got string
+	if err := client.Call(ctx, "m", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "m a"; got != want {
+		t.Errorf("got result %q, want %q", got, want)
+	}
+	if got, want := <-gotHeader, "Bearer x"; got != want {
+		t.Errorf("got Authorization header %q, want %q", got, want)
+	}
+
+	var respErr *jsonrpc2.Error
+	if err := client.Call(ctx, "fail", nil, nil); !errors.As(err, &respErr) || respErr.Code != 123 {
+		t.Errorf("got error %v, want error with code 123", err)
+	}
+	<-gotHeader
+
+	if err := client.Notify(ctx, "n", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-notified; got != "n" {
+		t.Errorf("got notification %q, want %q", got, "n")
+	}
+}
@@ -0,0 +1,49 @@
+package jsonrpc2
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// An IDGenerator returns the IDs of the requests sent by a Conn (see
+// GenerateIDs). It must return a different ID on each call, and may be
+// called concurrently if it is shared by several Conns.
+type IDGenerator func() ID
+
+// SequentialIDs returns an IDGenerator of the string IDs prefix+"1",
+// prefix+"2", and so on. Using a prefix that is unique to a process or
+// to a connection attempt, IDs remain unique across reconnects and
+// across Conns.
+func SequentialIDs(prefix string) IDGenerator {
+	var n uint64
+	return func() ID {
+		return ID{Str: prefix + strconv.FormatUint(atomic.AddUint64(&n, 1), 10), IsString: true}
+	}
+}
+
+// NodeIDs returns an IDGenerator of numeric IDs made of node in the
+// top 16 bits and of a counter in the remaining 48 bits. Conns that use
+// different nodes never send the same ID.
+//
+// The IDs are larger than 2^53, so they lose precision when peers
+// represent JSON numbers with floating-point values (like JavaScript).
+// Use the StringIDs ConnOpt with such peers.
+func NodeIDs(node uint16) IDGenerator {
+	var n uint64
+	return func() ID {
+		return ID{Num: uint64(node)<<48 | atomic.AddUint64(&n, 1)&(1<<48-1)}
+	}
+}
+
+// UUIDs is an IDGenerator of random (version 4) UUID string IDs.
+func UUIDs() ID {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("jsonrpc2: generating UUID: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // variant 10
+	return ID{Str: fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), IsString: true}
+}
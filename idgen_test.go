@@ -0,0 +1,85 @@
+package jsonrpc2_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"regexp"
+	"testing"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestIDGenerators(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := map[string]struct {
+		gen   jsonrpc2.IDGenerator
+		first jsonrpc2.ID
+		check func(jsonrpc2.ID) bool
+	}{
+		"SequentialIDs": {
+			gen:   jsonrpc2.SequentialIDs("a-"),
+			first: jsonrpc2.ID{Str: "a-1", IsString: true},
+		},
+		"NodeIDs": {
+			gen:   jsonrpc2.NodeIDs(3),
+			first: jsonrpc2.ID{Num: 3<<48 | 1},
+		},
+		"UUIDs": {
+			gen: jsonrpc2.UUIDs,
+			check: func(id jsonrpc2.ID) bool {
+				return id.IsString && uuid.MatchString(id.Str)
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			seen := map[jsonrpc2.ID]bool{}
+			for i := 0; i < 100; i++ {
+				id := test.gen()
+				if i == 0 && test.check == nil && id != test.first {
+					t.Errorf("got first ID %s, want %s", id, test.first)
+				}
+				if test.check != nil && !test.check(id) {
+					t.Errorf("got invalid ID %s", id)
+				}
+				if seen[id] {
+					t.Fatalf("got ID %s twice", id)
+				}
+				seen[id] = true
+			}
+		})
+	}
+}
+
+func TestGenerateIDs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := net.Pipe()
+	defer a.Close()
+	conn := jsonrpc2.NewConn(
+		ctx,
+		jsonrpc2.NewPlainObjectStream(b),
+		noopHandler{},
+		jsonrpc2.GenerateIDs(jsonrpc2.SequentialIDs("c1.")),
+	)
+	defer conn.Close()
+
+	go func() {
+		_, _ = conn.DispatchCall(ctx, "m", nil)
+		_, _ = conn.DispatchCall(ctx, "m", nil, jsonrpc2.PickID(jsonrpc2.ID{Num: 7}))
+		_, _ = conn.DispatchCall(ctx, "m", nil)
+	}()
+
+	dec := json.NewDecoder(a)
+	for _, want := range []string{`"c1.1"`, `7`, `"c1.2"`} {
+		var req struct{ ID json.RawMessage }
+		if err := dec.Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if string(req.ID) != want {
+			t.Errorf("got ID %s, want %s", req.ID, want)
+		}
+	}
+}
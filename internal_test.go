@@ -2,12 +2,14 @@ package jsonrpc2
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 )
 
 func TestAnyMessage(t *testing.T) {
 	tests := map[string]struct {
-		request, response, invalid bool
+		request, response, batch, invalid bool
+		invalidElements                   int // in a batch
 	}{
 		// Single messages
 		`{}`:                                   {invalid: true},
@@ -16,6 +18,21 @@ func TestAnyMessage(t *testing.T) {
 		`{"result":123}`:                       {response: true},
 		`{"result":null}`:                      {response: true},
 		`{"error":{"code":456,"message":"m"}}`: {response: true},
+		`{"method":null}`:                      {invalid: true},
+		`{"error":null}`:                       {invalid: true},
+		`{"method":"m","result":123}`:          {invalid: true},
+
+		// Batches
+		`[]`:                                     {invalid: true},
+		`[{"method":"m"},{"result":123}]`:        {batch: true, invalidElements: 1},
+		`[{"method":"m"},{"method":"n"}]`:        {batch: true},
+		`[{"result":123},{"result":null}]`:       {batch: true},
+		`[{"error":{"code":456,"message":"m"}}]`: {batch: true},
+		`[{"method":"m"},null]`:                  {batch: true, invalidElements: 1},
+		`[1]`:                                    {batch: true, invalidElements: 1},
+		`[[1,[2]],{},{"result":1}]`:              {batch: true, invalidElements: 2},
+		`[{"method":"m","id":{}}]`:               {batch: true, invalidElements: 1},
+		`[1,{"method":"m"}`:                      {invalid: true},
 	}
 	for s, want := range tests {
 		var m anyMessage
@@ -31,5 +48,53 @@ func TestAnyMessage(t *testing.T) {
 		if (m.response != nil) != want.response {
 			t.Errorf("%s: got response %v, want %v", s, m.response != nil, want.response)
 		}
+		if (m.batch != nil) != want.batch {
+			t.Errorf("%s: got batch %v, want %v", s, m.batch != nil, want.batch)
+		}
+		invalidElements := 0
+		for _, elem := range m.batch {
+			if elem.invalid != nil {
+				invalidElements++
+			}
+		}
+		if invalidElements != want.invalidElements {
+			t.Errorf("%s: got %d invalid elements, want %d", s, invalidElements, want.invalidElements)
+		}
+	}
+}
+
+func BenchmarkAnyMessage_UnmarshalJSON(b *testing.B) {
+	for _, bm := range benchmarkMessages() {
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(bm.data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var m anyMessage
+				if err := json.Unmarshal(bm.data, &m); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+type benchmarkMessage struct {
+	name string
+	data []byte
+}
+
+// benchmarkMessages returns typical messages, including a large
+// textDocument/didChange notification.
+func benchmarkMessages() []benchmarkMessage {
+	text, err := json.Marshal(strings.Repeat("func main() {\n\tfmt.Println(\"hello, world\")\n}\n", 2000))
+	if err != nil {
+		panic(err)
+	}
+	didChange := `{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a/b.go","version":42},"contentChanges":[{"text":` + string(text) + `}]}}`
+	return []benchmarkMessage{
+		{"request", []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///a/b.go"},"position":{"line":12,"character":34}}}`)},
+		{"response", []byte(`{"jsonrpc":"2.0","id":1,"result":{"contents":{"kind":"markdown","value":"func main()"},"range":{"start":{"line":12,"character":30},"end":{"line":12,"character":38}}}}`)},
+		{"batch", []byte(`[{"jsonrpc":"2.0","id":1,"method":"a","params":[1,2,3]},{"jsonrpc":"2.0","id":2,"method":"b","params":{"x":"y"}},{"jsonrpc":"2.0","method":"c"}]`)},
+		{"didChange", []byte(didChange)},
 	}
 }
@@ -3,6 +3,7 @@
 package jsonrpc2
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -61,57 +62,109 @@ const (
 type Handler interface {
 	// Handle is called to handle a request. No other requests are handled until
 	// it returns. If you do not require strict ordering behavior of received
-	// RPCs, it is suggested to wrap your handler in AsyncHandler. The context
-	// is automatically canceled when the connection closes.
+	// RPCs, it is suggested to wrap your handler in AsyncHandler.
+	//
+	// The context is specific to the request, and carries the Conn and the
+	// Request (see ConnFromContext and RequestFromContext). It is
+	// automatically canceled when the connection closes, when the handler
+	// timeout expires (see HandlerTimeout), or when the peer cancels the
+	// request (see PropagateCancellation). It is also canceled once Handle
+	// returns and the request is replied to (or once the request is
+	// handled, if the handler is wrapped in one of the async handlers of
+	// this package). The context of a notification is thus canceled once
+	// Handle returns, if the handler continues in the background in
+	// another way.
 	Handle(context.Context, *Conn, *Request)
 }
 
+// HandlerFunc is an adapter to allow the use of ordinary functions as
+// Handlers.
+type HandlerFunc func(context.Context, *Conn, *Request)
+
+// Handle implements Handler by calling f(ctx, conn, req).
+func (f HandlerFunc) Handle(ctx context.Context, conn *Conn, req *Request) {
+	f(ctx, conn, req)
+}
+
 // ID represents a JSON-RPC 2.0 request ID, which may be either a
-// string or number (or null, which is unsupported).
+// string, a number or null.
 type ID struct {
-	// At most one of Num or Str may be nonzero. If both are zero
-	// valued, then IsNum specifies which field's value is to be used
-	// as the ID.
+	// At most one of Num, Str or Number may be nonzero. If all are
+	// zero valued, then IsString and IsNull specify which field's
+	// value is to be used as the ID.
 	Num uint64
 	Str string
 
+	// Number holds the JSON literal of a numeric ID that Num can't
+	// represent, such as a negative or fractional number.
+	Number json.Number
+
 	// IsString controls whether the Num or Str field's value should be
 	// used as the ID, when both are zero valued. It must always be
 	// set to true if the request ID is a string.
 	IsString bool
+
+	// IsNull is true if the ID is null. A response has a null ID if
+	// the ID of the request could not be determined (e.g. Parse
+	// error/Invalid Request).
+	IsNull bool
 }
 
 func (id ID) String() string {
-	if id.IsString {
+	switch {
+	case id.IsNull:
+		return "null"
+	case id.IsString:
 		return strconv.Quote(id.Str)
+	case id.Number != "":
+		return string(id.Number)
 	}
 	return strconv.FormatUint(id.Num, 10)
 }
 
 // MarshalJSON implements json.Marshaler.
 func (id ID) MarshalJSON() ([]byte, error) {
-	if id.IsString {
+	switch {
+	case id.IsNull:
+		return []byte("null"), nil
+	case id.IsString:
 		return json.Marshal(id.Str)
+	case id.Number != "":
+		return json.Marshal(id.Number)
 	}
 	return json.Marshal(id.Num)
 }
 
 // UnmarshalJSON implements json.Unmarshaler.
 func (id *ID) UnmarshalJSON(data []byte) error {
-	// Support both uint64 and string IDs.
-	var v uint64
-	if err := json.Unmarshal(data, &v); err == nil {
-		*id = ID{Num: v}
-		return nil
-	}
-	var v2 string
-	if err := json.Unmarshal(data, &v2); err != nil {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
 		return err
 	}
-	*id = ID{Str: v2, IsString: true}
+	switch v := v.(type) {
+	case nil:
+		*id = ID{IsNull: true}
+	case string:
+		*id = ID{Str: v, IsString: true}
+	case json.Number:
+		*id = numberID(v)
+	default:
+		return fmt.Errorf("jsonrpc2: invalid ID %s", data)
+	}
 	return nil
 }
 
+// numberID returns the ID for the JSON number n, using Num if n is
+// an unsigned integer that it can represent.
+func numberID(n json.Number) ID {
+	if v, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return ID{Num: v}
+	}
+	return ID{Number: n}
+}
+
 // ErrClosed indicates that the JSON-RPC connection is closed (or in
 // the process of closing).
 var ErrClosed = errors.New("jsonrpc2: connection is closed")
@@ -77,6 +77,53 @@ func TestError_MarshalJSON(t *testing.T) {
 	}
 }
 
+func TestID_MarshalUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		data string
+		want jsonrpc2.ID
+	}{
+		{data: `0`, want: jsonrpc2.ID{}},
+		{data: `123`, want: jsonrpc2.ID{Num: 123}},
+		{data: `18446744073709551615`, want: jsonrpc2.ID{Num: 18446744073709551615}},
+		{data: `-1`, want: jsonrpc2.ID{Number: "-1"}},
+		{data: `-9223372036854775808`, want: jsonrpc2.ID{Number: "-9223372036854775808"}},
+		{data: `1.0`, want: jsonrpc2.ID{Number: "1.0"}},
+		{data: `1.5e3`, want: jsonrpc2.ID{Number: "1.5e3"}},
+		{data: `""`, want: jsonrpc2.ID{IsString: true}},
+		{data: `"a"`, want: jsonrpc2.ID{Str: "a", IsString: true}},
+		{data: `null`, want: jsonrpc2.ID{IsNull: true}},
+	}
+	for _, test := range tests {
+		var got jsonrpc2.ID
+		if err := json.Unmarshal([]byte(test.data), &got); err != nil {
+			t.Errorf("%s: %v", test.data, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.data, got, test.want)
+			continue
+		}
+		b, err := json.Marshal(got)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(b) != test.data {
+			t.Errorf("got JSON %s, want %s", b, test.data)
+		}
+		if got.String() != test.data {
+			t.Errorf("got String %s, want %s", got.String(), test.data)
+		}
+	}
+
+	for _, data := range []string{`true`, `{}`, `[1]`} {
+		var id jsonrpc2.ID
+		if err := json.Unmarshal([]byte(data), &id); err == nil {
+			t.Errorf("%s: got nil error, want error", data)
+		}
+	}
+}
+
 // testHandlerA is the "server" handler.
 type testHandlerA struct{ t *testing.T }
 
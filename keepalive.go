@@ -0,0 +1,87 @@
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrKeepaliveTimeout is the cause of the closing of a Conn whose
+	// peer did not answer a ping request in time (see Keepalive).
+	ErrKeepaliveTimeout = errors.New("jsonrpc2: keepalive timeout")
+
+	// ErrIdleTimeout is the cause of the closing of a Conn that was
+	// idle for too long (see IdleTimeout).
+	ErrIdleTimeout = errors.New("jsonrpc2: idle timeout")
+)
+
+// keepalive sends a ping request every c.keepaliveInterval, and closes
+// c if one is not answered within c.keepaliveTimeout.
+func (c *Conn) keepalive() {
+	ticker := time.NewTicker(c.keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.disconnect:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.keepaliveTimeout)
+		err := c.Call(ctx, c.keepaliveMethod, nil, nil)
+		cancel()
+		var respErr *Error
+		switch {
+		case err == nil || errors.As(err, &respErr):
+			// Any response shows that the peer is alive.
+		case errors.Is(err, context.DeadlineExceeded):
+			c.close(ErrKeepaliveTimeout)
+			return
+		case errors.Is(err, ErrClosed):
+			return
+		default:
+			c.logger.Printf("jsonrpc2: sending %s: %v\n", c.keepaliveMethod, err)
+		}
+	}
+}
+
+// handlePing replies to a ping request sent by the peer's keepalive.
+func (c *Conn) handlePing(ctx context.Context, req *Request) {
+	if err := c.Reply(ctx, req.ID, nil); err != nil && !errors.Is(err, ErrClosed) {
+		c.logger.Printf("jsonrpc2: replying to %s: %v\n", req.Method, err)
+	}
+}
+
+// watchIdle closes c once no message was sent or received for
+// c.idleTimeout.
+func (c *Conn) watchIdle() {
+	timer := time.NewTimer(c.idleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.activity:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(c.idleTimeout)
+		case <-timer.C:
+			c.close(ErrIdleTimeout)
+			return
+		case <-c.disconnect:
+			return
+		}
+	}
+}
+
+// active records that a message was sent or received, if IdleTimeout
+// is used.
+func (c *Conn) active() {
+	if c.activity == nil {
+		return
+	}
+	select {
+	case c.activity <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,121 @@
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps a Handler to add cross-cutting behavior to it, such
+// as logging, authorization or timeouts. AsyncHandler is a Middleware.
+type Middleware func(Handler) Handler
+
+// Chain returns h wrapped by the given middlewares. The first
+// middleware is the outermost one: it is the first to see each request,
+// and the last to see the Handle call return.
+//
+// Middlewares placed before AsyncHandler in the chain run on the
+// connection's read loop, and see Handle return as soon as the request
+// is dispatched. Middlewares that act on the whole handling of a request
+// (Recover, Timeout, Measure, LogRequests) must be placed after it.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i] != nil {
+			h = mws[i](h)
+		}
+	}
+	return h
+}
+
+// Recover is a middleware that recovers from panics in the handling of
+// requests, so that they don't crash the program. The panic and its
+// stack trace are logged to the Conn's Logger, and the request (unless
+// it is a notification, or was already replied to) is replied to with a
+// CodeInternalError error.
+//
+// A panic in a goroutine started by the handler is not recovered. In
+// particular, Recover must be placed after AsyncHandler in a chain to
+// recover from panics in asynchronous handlers.
+func Recover(h Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, conn *Conn, req *Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			conn.logger.Printf("jsonrpc2: panic handling %q: %v\n%s", req.Method, v, debug.Stack())
+			if req.Notif || !conn.awaitingReply(ctx, req.ID) {
+				return
+			}
+			respErr := &Error{Code: CodeInternalError, Message: "internal error"}
+			if err := conn.ReplyWithError(ctx, req.ID, respErr); err != nil && !errors.Is(err, ErrClosed) {
+				conn.logger.Printf("jsonrpc2: sending response %s: %v\n", req.ID, err)
+			}
+		}()
+		h.Handle(ctx, conn, req)
+	})
+}
+
+// Timeout returns a middleware that cancels the context of each request
+// after the given duration.
+func Timeout(timeout time.Duration) Middleware {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, conn *Conn, req *Request) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			h.Handle(ctx, conn, req)
+		})
+	}
+}
+
+// Authorize returns a middleware that calls check before handling each
+// request. If check returns an error, the request is not handled, and
+// is replied to with the error (as by HandlerWithError). Rejected
+// notifications are logged.
+func Authorize(check func(context.Context, *Conn, *Request) error) Middleware {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, conn *Conn, req *Request) {
+			err := check(ctx, conn, req)
+			if err == nil {
+				h.Handle(ctx, conn, req)
+				return
+			}
+			if req.Notif {
+				conn.logger.Printf("jsonrpc2: notification %q not authorized: %v\n", req.Method, err)
+				return
+			}
+			respErr, ok := err.(*Error)
+			if !ok {
+				respErr = &Error{Message: err.Error()}
+			}
+			if err := conn.ReplyWithError(ctx, req.ID, respErr); err != nil && !errors.Is(err, ErrClosed) {
+				conn.logger.Printf("jsonrpc2: sending response %s: %v\n", req.ID, err)
+			}
+		})
+	}
+}
+
+// Measure returns a middleware that calls record with the time each
+// request took to handle, for example to report metrics.
+func Measure(record func(ctx context.Context, req *Request, elapsed time.Duration)) Middleware {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, conn *Conn, req *Request) {
+			start := time.Now()
+			h.Handle(ctx, conn, req)
+			record(ctx, req, time.Since(start))
+		})
+	}
+}
+
+// LogRequests returns a middleware that logs the method of each request
+// and the time it took to handle.
+func LogRequests(logger Logger) Middleware {
+	return Measure(func(ctx context.Context, req *Request, elapsed time.Duration) {
+		if req.Notif {
+			logger.Printf("jsonrpc2: handled notif: %s (%s)\n", req.Method, elapsed)
+		} else {
+			logger.Printf("jsonrpc2: handled request #%s: %s (%s)\n", req.ID, req.Method, elapsed)
+		}
+	})
+}
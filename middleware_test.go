@@ -0,0 +1,186 @@
+package jsonrpc2_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestChain(t *testing.T) {
+	var got []string
+	trace := func(name string) jsonrpc2.Middleware {
+		return func(h jsonrpc2.Handler) jsonrpc2.Handler {
+			return jsonrpc2.HandlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+				got = append(got, name+" before")
+				h.Handle(ctx, conn, req)
+				got = append(got, name+" after")
+			})
+		}
+	}
+	h := jsonrpc2.HandlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+		got = append(got, "handler")
+	})
+
+	jsonrpc2.Chain(h, trace("a"), nil, trace("b")).Handle(context.Background(), nil, &jsonrpc2.Request{})
+
+	want := []string{"a before", "b before", "handler", "b after", "a after"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	ctx := context.Background()
+
+	rd, wr := io.Pipe()
+	defer rd.Close()
+	defer wr.Close()
+	logs := bufio.NewReader(rd)
+
+	h := jsonrpc2.HandlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+		_, hasDeadline := ctx.Deadline()
+		if err := conn.Reply(ctx, req.ID, hasDeadline); err != nil {
+			t.Error(err)
+		}
+	})
+	authorize := func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) error {
+		if req.Method == "forbidden" {
+			return errors.New("forbidden")
+		}
+		return nil
+	}
+	handler := jsonrpc2.Chain(h,
+		jsonrpc2.Authorize(authorize),
+		jsonrpc2.LogRequests(log.New(wr, "", 0)),
+		jsonrpc2.Timeout(time.Minute),
+	)
+	connA, connB := Pipe(ctx, noopHandler{}, handler)
+	defer connA.Close()
+	defer connB.Close()
+
+	var hasDeadline bool
+	if err := connA.Call(ctx, "m", nil, &hasDeadline); err != nil {
+		t.Fatal(err)
+	}
+	if !hasDeadline {
+		t.Error("got context without deadline, want deadline set by Timeout")
+	}
+	line, err := logs.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "jsonrpc2: handled request #0: m ("; !strings.HasPrefix(line, want) {
+		t.Errorf("got log %q, want prefix %q", line, want)
+	}
+
+	err = connA.Call(ctx, "forbidden", nil, nil)
+	respErr, ok := err.(*jsonrpc2.Error)
+	if !ok || respErr.Message != "forbidden" {
+		t.Fatalf("got error %v, want %q", err, "forbidden")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+
+	rd, wr := io.Pipe()
+	defer rd.Close()
+	defer wr.Close()
+	logs := bufio.NewReader(rd)
+
+	h := jsonrpc2.HandlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+		if req.Method == "panic" {
+			panic("oops")
+		}
+		if err := conn.Reply(ctx, req.ID, "ok"); err != nil {
+			t.Error(err)
+		}
+	})
+	a, b := net.Pipe()
+	connA := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(a), noopHandler{})
+	connB := jsonrpc2.NewConn(
+		ctx,
+		jsonrpc2.NewPlainObjectStream(b),
+		jsonrpc2.Chain(h, jsonrpc2.AsyncHandler, jsonrpc2.Recover),
+		jsonrpc2.SetLogger(log.New(wr, "", 0)),
+	)
+	defer connA.Close()
+	defer connB.Close()
+
+	go func() {
+		err := connA.Call(ctx, "panic", nil, nil)
+		respErr, ok := err.(*jsonrpc2.Error)
+		if !ok || respErr.Code != jsonrpc2.CodeInternalError {
+			t.Errorf("got error %v, want code %d", err, jsonrpc2.CodeInternalError)
+		}
+
+		// The connection is still usable.
+		if err := connA.Call(ctx, "m", nil, nil); err != nil {
+			t.Error(err)
+		}
+		wr.Close()
+	}()
+
+	line, err := logs.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "jsonrpc2: panic handling \"panic\": oops\n"; line != want {
+		t.Errorf("got log %q, want %q", line, want)
+	}
+	// Wait for the calls to complete.
+	if _, err := io.Copy(io.Discard, logs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecover_afterReply(t *testing.T) {
+	ctx := context.Background()
+
+	h := jsonrpc2.HandlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+		if err := conn.Reply(ctx, req.ID, "ok"); err != nil {
+			t.Error(err)
+		}
+		if req.Method == "panic" {
+			panic("oops")
+		}
+	})
+	var logs bytes.Buffer
+	a, b := net.Pipe()
+	connA := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(a), noopHandler{}, jsonrpc2.SetLogger(log.New(&logs, "", 0)))
+	connB := jsonrpc2.NewConn(
+		ctx,
+		jsonrpc2.NewPlainObjectStream(b),
+		jsonrpc2.Chain(h, jsonrpc2.Recover),
+		jsonrpc2.SetLogger(log.New(io.Discard, "", 0)),
+	)
+	defer connA.Close()
+	defer connB.Close()
+
+	var got string
+	if err := connA.Call(ctx, "panic", nil, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != "ok" {
+		t.Errorf("got result %q, want %q", got, "ok")
+	}
+
+	// The request isn't replied to twice: the next response read by
+	// connA is the one of the next call.
+	if err := connA.Call(ctx, "m", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if logs.Len() > 0 {
+		t.Errorf("got logs %q, want none", logs.String())
+	}
+}
@@ -0,0 +1,132 @@
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ServeMux is a Handler that dispatches requests and notifications to
+// the handlers registered for their method. Requests and notifications
+// have separate registrations.
+//
+// A pattern is either a method name, like "textDocument/hover", or a
+// method prefix ending in a slash, like "textDocument/", which matches
+// all methods beginning with it. A method name takes precedence over
+// prefixes, and longer prefixes take precedence over shorter ones.
+//
+// Requests with no matching handler are replied to with a
+// CodeMethodNotFound error. Notifications with no matching handler are
+// ignored, as the JSON-RPC spec requires.
+//
+// The zero value is an empty ServeMux ready to use.
+type ServeMux struct {
+	mu            sync.RWMutex
+	requests      muxEntries
+	notifications muxEntries
+}
+
+var _ Handler = (*ServeMux)(nil)
+
+// NewServeMux returns a new, empty ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{}
+}
+
+// HandleRequest registers the handler for requests matching pattern.
+// It panics if a handler is already registered for pattern.
+func (m *ServeMux) HandleRequest(pattern string, h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests.add("request", pattern, h)
+}
+
+// HandleNotification registers the handler for notifications matching
+// pattern. It panics if a handler is already registered for pattern.
+func (m *ServeMux) HandleNotification(pattern string, h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifications.add("notification", pattern, h)
+}
+
+// Handle implements Handler by dispatching req to the handler
+// registered for its method.
+func (m *ServeMux) Handle(ctx context.Context, conn *Conn, req *Request) {
+	m.mu.RLock()
+	var h Handler
+	if req.Notif {
+		h = m.notifications.match(req.Method)
+	} else {
+		h = m.requests.match(req.Method)
+	}
+	m.mu.RUnlock()
+
+	switch {
+	case h != nil:
+		h.Handle(ctx, conn, req)
+
+	case !req.Notif:
+		respErr := &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+		if err := conn.ReplyWithError(ctx, req.ID, respErr); err != nil && !errors.Is(err, ErrClosed) {
+			conn.logger.Printf("jsonrpc2: sending response %s: %v\n", req.ID, err)
+		}
+	}
+}
+
+// muxEntries holds the handlers registered in a ServeMux for either
+// requests or notifications.
+type muxEntries struct {
+	methods  map[string]Handler
+	prefixes []muxPrefix // sorted from longest to shortest
+}
+
+type muxPrefix struct {
+	prefix string
+	h      Handler
+}
+
+func (e *muxEntries) add(kind, pattern string, h Handler) {
+	if pattern == "" {
+		panic("jsonrpc2: invalid empty pattern")
+	}
+	if h == nil {
+		panic("jsonrpc2: nil handler")
+	}
+
+	if !strings.HasSuffix(pattern, "/") {
+		if _, exists := e.methods[pattern]; exists {
+			panic(fmt.Sprintf("jsonrpc2: multiple %s handlers for %s", kind, pattern))
+		}
+		if e.methods == nil {
+			e.methods = map[string]Handler{}
+		}
+		e.methods[pattern] = h
+		return
+	}
+
+	for _, p := range e.prefixes {
+		if p.prefix == pattern {
+			panic(fmt.Sprintf("jsonrpc2: multiple %s handlers for %s", kind, pattern))
+		}
+	}
+	e.prefixes = append(e.prefixes, muxPrefix{prefix: pattern, h: h})
+	sort.SliceStable(e.prefixes, func(i, j int) bool {
+		return len(e.prefixes[i].prefix) > len(e.prefixes[j].prefix)
+	})
+}
+
+// match returns the handler for method, or nil if there is none.
+func (e *muxEntries) match(method string) Handler {
+	if h, ok := e.methods[method]; ok {
+		return h
+	}
+	for _, p := range e.prefixes {
+		if strings.HasPrefix(method, p.prefix) {
+			return p.h
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package jsonrpc2_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestServeMux(t *testing.T) {
+	ctx := context.Background()
+
+	reply := func(result string) jsonrpc2.Handler {
+		return jsonrpc2.HandlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			if err := conn.Reply(ctx, req.ID, result); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	notified := make(chan string, 10)
+	record := func(name string) jsonrpc2.Handler {
+		return jsonrpc2.HandlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+			notified <- name + " " + req.Method
+		})
+	}
+
+	mux := jsonrpc2.NewServeMux()
+	mux.HandleRequest("initialize", reply("initialize"))
+	mux.HandleRequest("textDocument/", reply("textDocument/"))
+	mux.HandleRequest("textDocument/hover", reply("textDocument/hover"))
+	mux.HandleRequest("textDocument/semanticTokens/", reply("textDocument/semanticTokens/"))
+	mux.HandleNotification("textDocument/", record("textDocument/"))
+	mux.HandleNotification("exit", record("exit"))
+
+	connA, connB := Pipe(ctx, noopHandler{}, mux)
+	defer connA.Close()
+	defer connB.Close()
+
+	for method, want := range map[string]string{
+		"initialize":                        "initialize",
+		"textDocument/hover":                "textDocument/hover",
+		"textDocument/definition":           "textDocument/",
+		"textDocument/semanticTokens/full":  "textDocument/semanticTokens/",
+		"textDocument/semanticTokens/range": "textDocument/semanticTokens/",
+	} {
+		var got string
+		if err := connA.Call(ctx, method, nil, &got); err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", method, got, want)
+		}
+	}
+
+	t.Run("unknown request", func(t *testing.T) {
+		err := connA.Call(ctx, "exit", nil, nil)
+		respErr, ok := err.(*jsonrpc2.Error)
+		if !ok || respErr.Code != jsonrpc2.CodeMethodNotFound {
+			t.Fatalf("got error %v, want code %d", err, jsonrpc2.CodeMethodNotFound)
+		}
+	})
+
+	t.Run("notifications", func(t *testing.T) {
+		for _, method := range []string{"initialize", "textDocument/didOpen", "exit"} {
+			if err := connA.Notify(ctx, method, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Make sure the notifications have been handled.
+		if err := connA.Call(ctx, "initialize", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		close(notified)
+
+		var got []string
+		for s := range notified {
+			got = append(got, s)
+		}
+		want := []string{"textDocument/ textDocument/didOpen", "exit exit"}
+		if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("got notifications %q, want %q", got, want)
+		}
+	})
+}
+
+func TestServeMux_duplicate(t *testing.T) {
+	mux := jsonrpc2.NewServeMux()
+	mux.HandleRequest("m", noopHandler{})
+	mux.HandleNotification("m", noopHandler{})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic for duplicate pattern")
+		}
+	}()
+	mux.HandleRequest("m", noopHandler{})
+}
@@ -0,0 +1,145 @@
+package jsonrpc2
+
+import (
+	"context"
+)
+
+// maxOutboundBatch is the maximum number of messages written to the
+// stream at once by an outbound queue.
+const maxOutboundBatch = 128
+
+// outboundQueue holds the messages waiting to be written by the writer
+// goroutine of a Conn (see OutboundQueue). Responses are written
+// before requests, so that the peer is not kept waiting by a flood of
+// new requests and notifications.
+type outboundQueue struct {
+	responses chan outboundMessage
+	requests  chan outboundMessage
+}
+
+// outboundMessage is a message in an outbound queue. If m is nil,
+// flushed is closed once the messages queued before it are written.
+type outboundMessage struct {
+	m       *anyMessage
+	flushed chan struct{}
+}
+
+func newOutboundQueue(size int) *outboundQueue {
+	return &outboundQueue{
+		responses: make(chan outboundMessage, size),
+		requests:  make(chan outboundMessage, size),
+	}
+}
+
+// enqueue adds m to the outbound queue of c, waiting for room in the
+// queue until ctx is done.
+func (c *Conn) enqueue(ctx context.Context, m *anyMessage) error {
+	q := c.outbound.requests
+	if m.response != nil || (len(m.batch) > 0 && m.batch[0].response != nil) {
+		q = c.outbound.responses
+	}
+	select {
+	case q <- outboundMessage{m: m}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.disconnect:
+		return ErrClosed
+	}
+}
+
+// flush waits until the messages queued so far are written.
+func (c *Conn) flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case c.outbound.requests <- outboundMessage{flushed: flushed}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.disconnect:
+		return ErrClosed
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.disconnect:
+		return ErrClosed
+	}
+}
+
+// writeMessages writes the messages of the outbound queue of c to the
+// stream, until c is closed. The messages available at once are
+// written together, with a single flush if the stream implements
+// ObjectsWriter.
+func (c *Conn) writeMessages() {
+	q := c.outbound
+	var responses, requests []outboundMessage
+	for {
+		responses, requests = responses[:0], requests[:0]
+		select {
+		case om := <-q.responses:
+			responses = append(responses, om)
+		case om := <-q.requests:
+			requests = append(requests, om)
+		case <-c.disconnect:
+			return
+		}
+	drain:
+		for len(responses)+len(requests) < maxOutboundBatch {
+			select {
+			case om := <-q.responses:
+				responses = append(responses, om)
+				continue
+			default:
+			}
+			select {
+			case om := <-q.requests:
+				requests = append(requests, om)
+			default:
+				break drain
+			}
+		}
+
+		objs := make([]interface{}, 0, len(responses)+len(requests))
+		for _, oms := range [][]outboundMessage{responses, requests} {
+			for _, om := range oms {
+				if om.m != nil {
+					objs = append(objs, om.m)
+				}
+			}
+		}
+		if err := c.writeObjects(objs); err != nil {
+			c.close(err)
+			return
+		}
+		for _, om := range requests {
+			if om.flushed != nil {
+				close(om.flushed)
+			}
+		}
+	}
+}
+
+// writeObjects writes objs to the stream at once.
+func (c *Conn) writeObjects(objs []interface{}) error {
+	if len(objs) == 0 {
+		return nil
+	}
+	c.sending <- struct{}{}
+	defer c.unlockSending()
+
+	if w, ok := c.stream.(ObjectsWriter); ok {
+		if err := w.WriteObjects(objs); err != nil {
+			return err
+		}
+	} else {
+		for _, obj := range objs {
+			if err := c.stream.WriteObject(obj); err != nil {
+				return err
+			}
+		}
+	}
+	c.active()
+	return nil
+}
@@ -0,0 +1,311 @@
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ConnState is the state of the connection of a ReconnectingClient.
+type ConnState int
+
+const (
+	// StateConnecting means that the client is dialing the server or
+	// running the handshake, or waiting to do so again.
+	StateConnecting ConnState = iota
+
+	// StateConnected means that the client is connected, and that the
+	// handshake succeeded.
+	StateConnected
+
+	// StateDisconnected means that the connection was lost. The client
+	// reconnects next.
+	StateDisconnected
+
+	// StateClosed means that the client was closed.
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+)
+
+// A ReconnectingClient is a JSON-RPC client that connects to the server
+// through Dial, and transparently reconnects when the connection is
+// lost, for instance when the server restarts. It connects on first
+// use. The exported fields must not be changed after first use.
+//
+// Calls and notifications made while the client is not connected wait
+// for the connection. When the connection is lost, the calls awaiting
+// their response fail, unless Replay is set.
+type ReconnectingClient struct {
+	// Dial opens a connection to the server, and returns its stream.
+	Dial func(ctx context.Context) (ObjectStream, error)
+
+	// Handler handles the requests from the server. If nil, they are
+	// answered with a CodeMethodNotFound error.
+	Handler Handler
+
+	// ConnOpts are the options passed to NewConn.
+	ConnOpts []ConnOpt
+
+	// Handshake, if not nil, is called on each new connection before
+	// it is used, for instance to send an initialize request. If it
+	// fails, the connection is closed and the client reconnects.
+	Handshake func(ctx context.Context, conn *Conn) error
+
+	// Replay causes calls and notifications that failed because the
+	// connection was lost to be sent again once the client is
+	// reconnected. Only set it if the requests are idempotent, since
+	// the server may have handled them already.
+	Replay bool
+
+	// MinBackoff and MaxBackoff bound the exponential backoff between
+	// two connection attempts, to which a random jitter is applied.
+	// They default to 100ms and 10s. The client also waits before
+	// reconnecting after the connection is lost, and the backoff is
+	// only reset once a connection stayed up for MaxBackoff, so that a
+	// server that drops connections right away isn't redialed in a
+	// tight loop.
+	MinBackoff, MaxBackoff time.Duration
+
+	// States, if not nil, receives the state changes of the client.
+	// They are dropped if the channel is not ready, so it should be
+	// buffered.
+	States chan<- ConnState
+
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	conn    *Conn         // the current connection, if connected
+	ready   chan struct{} // closed when conn is set
+	lost    chan struct{} // closed when conn is unset
+	ctx     context.Context
+	cancel  context.CancelFunc
+	runDone chan struct{}
+}
+
+var _ JSONRPC2 = (*ReconnectingClient)(nil)
+
+// Call issues a call on the current connection, and waits for its
+// response. See (*Conn).Call.
+func (c *ReconnectingClient) Call(ctx context.Context, method string, params, result interface{}, opts ...CallOption) error {
+	var conn *Conn
+	for {
+		var err error
+		conn, err = c.current(ctx, conn)
+		if err != nil {
+			return err
+		}
+		err = conn.Call(ctx, method, params, result, opts...)
+		if !c.shouldReplay(err) {
+			return err
+		}
+	}
+}
+
+// Notify sends a notification on the current connection. See
+// (*Conn).Notify.
+func (c *ReconnectingClient) Notify(ctx context.Context, method string, params interface{}, opts ...CallOption) error {
+	var conn *Conn
+	for {
+		var err error
+		conn, err = c.current(ctx, conn)
+		if err != nil {
+			return err
+		}
+		err = conn.Notify(ctx, method, params, opts...)
+		if !c.shouldReplay(err) {
+			return err
+		}
+	}
+}
+
+// shouldReplay reports whether a request that failed with err must be
+// sent again.
+func (c *ReconnectingClient) shouldReplay(err error) bool {
+	if !c.Replay || !errors.Is(err, ErrClosed) {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.closed
+}
+
+// Close closes the client and its connection. Calls waiting for the
+// connection fail with ErrClosed.
+func (c *ReconnectingClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.closed = true
+	started := c.started
+	c.mu.Unlock()
+
+	if !started {
+		c.setState(StateClosed)
+		return nil
+	}
+	c.cancel()
+	<-c.runDone
+	return nil
+}
+
+// current returns the current connection, waiting for it if the
+// client is not connected, or if the current connection is the lost
+// connection prev.
+func (c *ReconnectingClient) current(ctx context.Context, prev *Conn) (*Conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if !c.started {
+		c.started = true
+		c.ready = make(chan struct{})
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+		c.runDone = make(chan struct{})
+		go c.run()
+	}
+	conn, wait, done := c.conn, c.ready, c.ctx.Done()
+	if conn != nil && conn == prev {
+		wait = c.lost
+	}
+	c.mu.Unlock()
+	if conn != nil && conn != prev {
+		return conn, nil
+	}
+
+	select {
+	case <-wait:
+		return c.current(ctx, prev)
+	case <-done:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run connects to the server, and reconnects each time the connection
+// is lost, until the client is closed.
+func (c *ReconnectingClient) run() {
+	defer close(c.runDone)
+	defer c.setState(StateClosed)
+
+	minBackoff, maxBackoff := c.MinBackoff, c.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = defaultMaxBackoff
+		if maxBackoff < minBackoff {
+			maxBackoff = minBackoff
+		}
+	}
+
+	backoff := minBackoff
+	// sleep waits for the backoff, and doubles it. It returns false if
+	// the client was closed in the meantime.
+	sleep := func() bool {
+		// Equal jitter: wait between backoff/2 and backoff.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-time.After(wait):
+		case <-c.ctx.Done():
+			return false
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		return true
+	}
+
+	for {
+		c.setState(StateConnecting)
+		conn, err := c.connect()
+		if err != nil {
+			if !sleep() {
+				return
+			}
+			continue
+		}
+		connected := time.Now()
+
+		c.mu.Lock()
+		c.conn = conn
+		c.lost = make(chan struct{})
+		close(c.ready)
+		c.mu.Unlock()
+		c.setState(StateConnected)
+
+		select {
+		case <-conn.DisconnectNotify():
+		case <-c.ctx.Done():
+			conn.Close()
+			return
+		}
+
+		c.mu.Lock()
+		c.conn = nil
+		c.ready = make(chan struct{})
+		close(c.lost)
+		c.mu.Unlock()
+		c.setState(StateDisconnected)
+
+		if time.Since(connected) >= maxBackoff {
+			backoff = minBackoff
+		}
+		if !sleep() {
+			return
+		}
+	}
+}
+
+// connect dials the server and runs the handshake.
+func (c *ReconnectingClient) connect() (*Conn, error) {
+	stream, err := c.Dial(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	h := c.Handler
+	if h == nil {
+		h = &ServeMux{}
+	}
+	conn := NewConn(c.ctx, stream, h, c.ConnOpts...)
+	if c.Handshake != nil {
+		if err := c.Handshake(c.ctx, conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *ReconnectingClient) setState(s ConnState) {
+	if c.States == nil {
+		return
+	}
+	select {
+	case c.States <- s:
+	default:
+	}
+}
@@ -0,0 +1,168 @@
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// testDialer dials in-memory servers, one per connection, whose
+// handler is h. It fails the first failures dials.
+type testDialer struct {
+	h        func(dial int) jsonrpc2.Handler
+	failures int
+
+	mu    sync.Mutex
+	dials int
+	conns []*jsonrpc2.Conn
+}
+
+func (d *testDialer) Dial(ctx context.Context) (jsonrpc2.ObjectStream, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials++
+	if d.dials <= d.failures {
+		return nil, errors.New("dial failed")
+	}
+	a, b := net.Pipe()
+	d.conns = append(d.conns, jsonrpc2.NewConn(context.Background(), jsonrpc2.NewPlainObjectStream(b), d.h(d.dials)))
+	return jsonrpc2.NewPlainObjectStream(a), nil
+}
+
+func (d *testDialer) serverConn(i int) *jsonrpc2.Conn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conns[i]
+}
+
+func TestReconnectingClient(t *testing.T) {
+	ctx := context.Background()
+
+	dialer := &testDialer{
+		h: func(dial int) jsonrpc2.Handler {
+			return jsonrpc2.HandlerWithError(func(context.Context, *jsonrpc2.Conn, *jsonrpc2.Request) (interface{}, error) {
+				return dial, nil
+			})
+		},
+		failures: 2,
+	}
+	var handshakes int
+	states := make(chan jsonrpc2.ConnState, 10)
+	c := &jsonrpc2.ReconnectingClient{
+		Dial: dialer.Dial,
+		Handshake: func(ctx context.Context, conn *jsonrpc2.Conn) error {
+			handshakes++
+			return conn.Notify(ctx, "initialized", nil)
+		},
+		MinBackoff: time.Millisecond,
+		States:     states,
+	}
+
+	var got int
+	if err := c.Call(ctx, "m", nil, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := 3; got != want {
+		t.Errorf("got result %d, want %d", got, want)
+	}
+
+	// The server goes away, and the client reconnects.
+	dialer.serverConn(0).Close()
+	for _, want := range []jsonrpc2.ConnState{
+		jsonrpc2.StateConnecting,
+		jsonrpc2.StateConnecting,
+		jsonrpc2.StateConnecting,
+		jsonrpc2.StateConnected,
+		jsonrpc2.StateDisconnected,
+		jsonrpc2.StateConnecting,
+		jsonrpc2.StateConnected,
+	} {
+		if got := <-states; got != want {
+			t.Fatalf("got state %s, want %s", got, want)
+		}
+	}
+	if err := c.Call(ctx, "m", nil, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := 4; got != want {
+		t.Errorf("got result %d, want %d", got, want)
+	}
+	if want := 2; handshakes != want {
+		t.Errorf("got %d handshakes, want %d", handshakes, want)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-states, jsonrpc2.StateClosed; got != want {
+		t.Errorf("got state %s, want %s", got, want)
+	}
+	if err := c.Call(ctx, "m", nil, nil); !errors.Is(err, jsonrpc2.ErrClosed) {
+		t.Errorf("got error %v, want %v", err, jsonrpc2.ErrClosed)
+	}
+}
+
+func TestReconnectingClient_Replay(t *testing.T) {
+	// The first server closes the connection instead of replying.
+	dialer := &testDialer{
+		h: func(dial int) jsonrpc2.Handler {
+			return jsonrpc2.HandlerWithError(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+				if dial == 1 {
+					conn.Close()
+				}
+				return dial, nil
+			})
+		},
+	}
+
+	for _, replay := range []bool{false, true} {
+		ctx := context.Background()
+		dialer.dials = 0
+		c := &jsonrpc2.ReconnectingClient{Dial: dialer.Dial, Replay: replay, MinBackoff: time.Millisecond}
+
+		var got int
+		err := c.Call(ctx, "m", nil, &got)
+		if replay && (err != nil || got != 2) {
+			t.Errorf("with replay: got result %d and error %v, want 2", got, err)
+		}
+		if !replay && !errors.Is(err, jsonrpc2.ErrClosed) {
+			t.Errorf("without replay: got error %v, want %v", err, jsonrpc2.ErrClosed)
+		}
+		c.Close()
+	}
+}
+
+func TestReconnectingClient_backoff(t *testing.T) {
+	// The server closes each connection right away.
+	var mu sync.Mutex
+	dials := 0
+	c := &jsonrpc2.ReconnectingClient{
+		Dial: func(ctx context.Context) (jsonrpc2.ObjectStream, error) {
+			mu.Lock()
+			dials++
+			mu.Unlock()
+			a, b := net.Pipe()
+			b.Close()
+			return jsonrpc2.NewPlainObjectStream(a), nil
+		},
+		MinBackoff: 10 * time.Millisecond,
+	}
+	defer c.Close()
+
+	// The client connects on first use.
+	_ = c.Notify(context.Background(), "m", nil)
+	time.Sleep(200 * time.Millisecond)
+
+	// The client waits between the attempts, with a backoff of 10ms,
+	// 20ms, 40ms and so on.
+	mu.Lock()
+	defer mu.Unlock()
+	if max := 10; dials > max {
+		t.Errorf("got %d dials, want at most %d", dials, max)
+	}
+}
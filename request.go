@@ -3,7 +3,6 @@ package jsonrpc2
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 )
 
@@ -31,107 +30,76 @@ type Request struct {
 }
 
 // MarshalJSON implements json.Marshaler and adds the "jsonrpc":"2.0"
-// property.
+// property. ExtraFields are written after the other fields, in order.
+// The raw JSON values of Params, Meta and ExtraFields are written as
+// is, byte for byte, once checked to be valid JSON.
 func (r Request) MarshalJSON() ([]byte, error) {
-	r2 := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  r.Method,
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	var err error
+	writeRaw := func(name string, value []byte) {
+		if err != nil {
+			return
+		}
+		var key []byte
+		if key, err = json.Marshal(name); err != nil {
+			return
+		}
+		if value == nil {
+			value = jsonNull
+		} else if !json.Valid(value) {
+			err = fmt.Errorf("invalid JSON value of field %q", name)
+			return
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
 	}
-	for _, field := range r.ExtraFields {
-		r2[field.Name] = field.Value
+	writeField := func(name string, v interface{}) {
+		if err != nil {
+			return
+		}
+		var value []byte
+		if value, err = json.Marshal(v); err != nil {
+			return
+		}
+		writeRaw(name, value)
 	}
 	if !r.Notif {
-		r2["id"] = &r.ID
-	}
-	if r.Params != nil {
-		r2["params"] = r.Params
+		writeField("id", &r.ID)
 	}
+	writeField("jsonrpc", "2.0")
 	if r.Meta != nil {
-		r2["meta"] = r.Meta
+		writeRaw("meta", *r.Meta)
 	}
-	return json.Marshal(r2)
-}
-
-// UnmarshalJSON implements json.Unmarshaler.
-func (r *Request) UnmarshalJSON(data []byte) error {
-	r2 := make(map[string]interface{})
-	pop := func(key string) interface{} {
-		defer delete(r2, key)
-		return r2[key]
-	}
-
-	// Detect if the "params" or "meta" fields are JSON "null" or just not
-	// present by seeing if the field gets overwritten to nil.
-	emptyParams := &json.RawMessage{}
-	r2["params"] = emptyParams
-	emptyMeta := &json.RawMessage{}
-	r2["meta"] = emptyMeta
-
-	decoder := json.NewDecoder(bytes.NewReader(data))
-	decoder.UseNumber()
-	if err := decoder.Decode(&r2); err != nil {
-		return err
-	}
-
-	var ok bool
-	r.Method, ok = pop("method").(string)
-	if !ok {
-		return errors.New("missing method field")
-	}
-	switch params := pop("params"); params {
-	case nil:
-		r.Params = &jsonNull
-	case emptyParams:
-		r.Params = nil
-	default:
-		b, err := json.Marshal(params)
-		if err != nil {
-			return fmt.Errorf("failed to marshal params: %w", err)
-		}
-		r.Params = (*json.RawMessage)(&b)
+	writeField("method", r.Method)
+	if r.Params != nil {
+		writeRaw("params", *r.Params)
 	}
-	switch meta := pop("meta"); meta {
-	case nil:
-		r.Meta = &jsonNull
-	case emptyMeta:
-		r.Meta = nil
-	default:
-		b, err := json.Marshal(meta)
-		if err != nil {
-			return fmt.Errorf("failed to marshal Meta: %w", err)
+	for _, field := range r.ExtraFields {
+		if isReservedField(field.Name) {
+			return nil, fmt.Errorf("invalid extra field %q", field.Name)
 		}
-		r.Meta = (*json.RawMessage)(&b)
+		writeRaw(field.Name, field.Value)
 	}
-	switch rawID := pop("id").(type) {
-	case nil:
-		r.ID = ID{}
-		r.Notif = true
-	case string:
-		r.ID = ID{Str: rawID, IsString: true}
-		r.Notif = false
-	case json.Number:
-		id, err := rawID.Int64()
-		if err != nil {
-			return fmt.Errorf("failed to unmarshal ID: %w", err)
-		}
-		r.ID = ID{Num: uint64(id)}
-		r.Notif = false
-	default:
-		return fmt.Errorf("unexpected ID type: %T", rawID)
+	if err != nil {
+		return nil, err
 	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
 
-	// The jsonrpc field should not be added to ExtraFields.
-	delete(r2, "jsonrpc")
-
-	// Clear the extra fields before populating them again.
-	r.ExtraFields = nil
-	for name, value := range r2 {
-		r.ExtraFields = append(r.ExtraFields, RequestField{
-			Name:  name,
-			Value: value,
-		})
+// UnmarshalJSON implements json.Unmarshaler. Params and Meta hold the
+// raw JSON of the fields, without decoding them.
+func (r *Request) UnmarshalJSON(data []byte) error {
+	var f messageFields
+	if err := readMessageFields(json.NewDecoder(bytes.NewReader(data)), &f); err != nil {
+		return err
 	}
-	return nil
+	return f.decodeRequest(r)
 }
 
 // SetParams sets r.Params to the JSON encoding of v. If JSON
@@ -160,19 +128,60 @@ func (r *Request) SetMeta(v interface{}) error {
 // JSON encoding of the request, as a way to add arbitrary extensions to
 // JSON RPC 2.0. If JSON marshaling fails, it returns an error.
 func (r *Request) SetExtraField(name string, v interface{}) error {
-	switch name {
-	case "id", "jsonrpc", "meta", "method", "params":
+	if isReservedField(name) {
 		return fmt.Errorf("invalid extra field %q", name)
 	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
 	r.ExtraFields = append(r.ExtraFields, RequestField{
 		Name:  name,
-		Value: v,
+		Value: b,
 	})
 	return nil
 }
 
+// ExtraField returns the first entry of r.ExtraFields with the given
+// name, and whether there is one.
+func (r *Request) ExtraField(name string) (RequestField, bool) {
+	for _, field := range r.ExtraFields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return RequestField{}, false
+}
+
+// isReservedField reports whether name is the name of a field of the
+// request object defined by JSON-RPC 2.0, or of the Meta field.
+func isReservedField(name string) bool {
+	switch name {
+	case "id", "jsonrpc", "meta", "method", "params":
+		return true
+	}
+	return false
+}
+
 // RequestField is a top-level field that can be added to the JSON-RPC request.
+//
+// Its Name must not be the name of a field of the request object ("id",
+// "jsonrpc", "method" and "params") or "meta": marshaling a Request
+// with such an extra field fails, rather than overwriting the field.
 type RequestField struct {
-	Name  string
-	Value interface{}
+	Name string
+
+	// Value is the raw JSON value of the field. When a request is
+	// unmarshaled, it holds the original bytes, and they are written
+	// as is when the request is marshaled. Use SetExtraField to set it
+	// to the JSON encoding of a Go value.
+	//
+	// NOTE: Value used to be an interface{} holding a Go value that was
+	// encoded when the request was marshaled.
+	Value json.RawMessage
+}
+
+// Decode stores the JSON value of f in the value pointed to by v.
+func (f RequestField) Decode(v interface{}) error {
+	return json.Unmarshal(f.Value, v)
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/sourcegraph/jsonrpc2"
@@ -39,7 +40,27 @@ func TestRequest_MarshalUnmarshalJSON(t *testing.T) {
 		},
 		{
 			data: []byte(`{"id":123,"jsonrpc":"2.0","method":"m","sessionId":"session"}`),
-			want: jsonrpc2.Request{ID: jsonrpc2.ID{Num: 123}, Method: "m", Params: nil, ExtraFields: []jsonrpc2.RequestField{{Name: "sessionId", Value: "session"}}},
+			want: jsonrpc2.Request{ID: jsonrpc2.ID{Num: 123}, Method: "m", Params: nil, ExtraFields: []jsonrpc2.RequestField{{Name: "sessionId", Value: json.RawMessage(`"session"`)}}},
+		},
+		{
+			// Extra fields keep their order and their raw values.
+			data: []byte(`{"id":123,"jsonrpc":"2.0","method":"m","z":12345678901234567890123,"a":{"y":1,"x":2}}`),
+			want: jsonrpc2.Request{ID: jsonrpc2.ID{Num: 123}, Method: "m", ExtraFields: []jsonrpc2.RequestField{
+				{Name: "z", Value: json.RawMessage(`12345678901234567890123`)},
+				{Name: "a", Value: json.RawMessage(`{"y":1,"x":2}`)},
+			}},
+		},
+		{
+			data: []byte(`{"id":-1,"jsonrpc":"2.0","method":"m"}`),
+			want: jsonrpc2.Request{ID: jsonrpc2.ID{Number: "-1"}, Method: "m"},
+		},
+		{
+			data: []byte(`{"id":null,"jsonrpc":"2.0","method":"m"}`),
+			want: jsonrpc2.Request{ID: jsonrpc2.ID{IsNull: true}, Method: "m"},
+		},
+		{
+			data: []byte(`{"jsonrpc":"2.0","method":"m"}`),
+			want: jsonrpc2.Request{Method: "m", Notif: true},
 		},
 	}
 	for _, test := range tests {
@@ -62,3 +83,122 @@ func TestRequest_MarshalUnmarshalJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestRequest_UnmarshalJSON_raw(t *testing.T) {
+	// Params and Meta keep the original bytes.
+	data := []byte(`{"method":"m","params":{"b": 1.50, "a":12345678901234567890},"meta":[ 1 ]}`)
+	var r jsonrpc2.Request
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"b": 1.50, "a":12345678901234567890}`; r.Params == nil || string(*r.Params) != want {
+		t.Errorf("got params %s, want %s", r.Params, want)
+	}
+	if want := `[ 1 ]`; r.Meta == nil || string(*r.Meta) != want {
+		t.Errorf("got meta %s, want %s", r.Meta, want)
+	}
+
+	for _, data := range []string{
+		`{"id":1}`,
+		`{"id":1,"method":null}`,
+		`{"id":1,"method":123}`,
+		`{"id":{},"method":"m"}`,
+		`[{"id":1,"method":"m"}]`,
+	} {
+		if err := json.Unmarshal([]byte(data), &r); err == nil {
+			t.Errorf("%s: got nil error, want an error", data)
+		}
+	}
+}
+
+func TestRequest_MarshalJSON_raw(t *testing.T) {
+	// The raw values are written back byte for byte, including on the
+	// wire.
+	data := `{"id":1,"jsonrpc":"2.0","meta":{ "t": "<m>" },"method":"m","params":[1, 2.50,  "<p>"],"sig":{"b": "<x>",  "a":1}}`
+	var r jsonrpc2.Request
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data {
+		t.Errorf("got JSON %s, want %s", got, data)
+	}
+
+	var buf bytes.Buffer
+	if err := (jsonrpc2.VSCodeObjectCodec{}).WriteObject(&buf, &r); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(buf.String(), "\r\n\r\n"+data) {
+		t.Errorf("got written message %q, want %q", buf.String(), data)
+	}
+	buf.Reset()
+	if err := (jsonrpc2.PlainObjectCodec{}).WriteObject(&buf, &r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), data+"\n"; got != want {
+		t.Errorf("got written message %q, want %q", got, want)
+	}
+
+	r.ExtraFields = []jsonrpc2.RequestField{{Name: "sig", Value: json.RawMessage(`{"b":`)}}
+	if _, err := r.MarshalJSON(); err == nil {
+		t.Error("got nil error marshaling an invalid extra field, want an error")
+	}
+}
+
+func TestRequest_ExtraField(t *testing.T) {
+	var r jsonrpc2.Request
+	if err := r.SetExtraField("n", 1.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetExtraField("params", 1); err == nil {
+		t.Error("got nil error for reserved field, want an error")
+	}
+
+	field, ok := r.ExtraField("n")
+	if !ok {
+		t.Fatal("extra field not found")
+	}
+	var n float64
+	if err := field.Decode(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1.5 {
+		t.Errorf("got %v, want 1.5", n)
+	}
+	if _, ok := r.ExtraField("m"); ok {
+		t.Error("got an unset extra field")
+	}
+
+	r.ExtraFields = append(r.ExtraFields, jsonrpc2.RequestField{Name: "method", Value: json.RawMessage(`"m"`)})
+	if _, err := json.Marshal(r); err == nil {
+		t.Error("got nil error marshaling a reserved extra field, want an error")
+	}
+}
+
+func BenchmarkRequest_UnmarshalJSON(b *testing.B) {
+	text, err := json.Marshal(strings.Repeat("func main() {\n\tfmt.Println(\"hello, world\")\n}\n", 2000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bm := range []struct {
+		name string
+		data []byte
+	}{
+		{"small", []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///a/b.go"},"position":{"line":12,"character":34}},"meta":{"trace":"abc"}}`)},
+		{"didChange", []byte(`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a/b.go","version":42},"contentChanges":[{"text":` + string(text) + `}]}}`)},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(bm.data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var r jsonrpc2.Request
+				if err := json.Unmarshal(bm.data, &r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
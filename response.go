@@ -1,6 +1,7 @@
 package jsonrpc2
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 )
@@ -17,13 +18,6 @@ type Response struct {
 	// NOTE: It is not part of spec. However, it is useful for propagating
 	// tracing context, etc.
 	Meta *json.RawMessage `json:"meta,omitempty"`
-
-	// SPEC NOTE: The spec says "If there was an error in detecting
-	// the id in the Request object (e.g. Parse error/Invalid
-	// Request), it MUST be Null." If we made the ID field nullable,
-	// then we'd have to make it a pointer type. For simplicity, we're
-	// ignoring the case where there was an error in detecting the ID
-	// in the Request object.
 }
 
 // MarshalJSON implements json.Marshaler and adds the "jsonrpc":"2.0"
@@ -43,21 +37,11 @@ func (r Response) MarshalJSON() ([]byte, error) {
 
 // UnmarshalJSON implements json.Unmarshaler.
 func (r *Response) UnmarshalJSON(data []byte) error {
-	type tmpType Response
-
-	// Detect if the "result" field is JSON "null" or just not present
-	// by seeing if the field gets overwritten to nil.
-	*r = Response{Result: &json.RawMessage{}}
-
-	if err := json.Unmarshal(data, (*tmpType)(r)); err != nil {
+	var f messageFields
+	if err := readMessageFields(json.NewDecoder(bytes.NewReader(data)), &f); err != nil {
 		return err
 	}
-	if r.Result == nil { // JSON "null"
-		r.Result = &jsonNull
-	} else if len(*r.Result) == 0 {
-		r.Result = nil
-	}
-	return nil
+	return f.decodeResponse(r)
 }
 
 // SetResult sets r.Result to the JSON representation of v. If JSON
@@ -73,6 +73,10 @@ func TestResponse_MarshalUnmarshalJSON(t *testing.T) {
 			data: []byte(`{"id":123,"result":null,"jsonrpc":"2.0"}`),
 			want: jsonrpc2.Response{ID: jsonrpc2.ID{Num: 123}, Result: &jsonNull},
 		},
+		{
+			data: []byte(`{"id":null,"error":{"code":-32700,"message":"Parse error"},"jsonrpc":"2.0"}`),
+			want: jsonrpc2.Response{ID: jsonrpc2.ID{IsNull: true}, Error: &jsonrpc2.Error{Code: jsonrpc2.CodeParseError, Message: "Parse error"}},
+		},
 		{
 			data:  []byte(`{"id":123,"jsonrpc":"2.0"}`),
 			want:  jsonrpc2.Response{ID: jsonrpc2.ID{Num: 123}, Result: nil},
@@ -0,0 +1,238 @@
+package jsonrpc2
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by (*Server).Serve after a call to
+// Shutdown or Close.
+var ErrServerClosed = errors.New("jsonrpc2: server closed")
+
+// A Server accepts connections from a net.Listener (such as a TCP or
+// Unix socket listener) and runs a Conn for each of them. The exported
+// fields must not be changed once Serve is called.
+type Server struct {
+	// Codec is the codec of the streams of the connections (see
+	// NewBufferedStream). If nil, the connections use plain JSON
+	// objects (see NewPlainObjectStream).
+	Codec ObjectCodec
+
+	// NewHandler returns the handler of the Conn of an accepted
+	// connection.
+	NewHandler func(nc net.Conn) Handler
+
+	// ConnOpts are the options passed to NewConn.
+	ConnOpts []ConnOpt
+
+	// MaxConns is the maximum number of connections served at once. If
+	// it is reached, the server stops accepting connections until one
+	// is closed. If zero, there is no limit.
+	MaxConns int
+
+	// OnConnect, if not nil, is called with the Conn of each accepted
+	// connection, before it is served: the Conn doesn't read messages
+	// until OnConnect returns.
+	OnConnect func(*Conn)
+
+	// OnDisconnect, if not nil, is called with the Conn of each
+	// connection once it is closed.
+	OnDisconnect func(*Conn)
+
+	mu         sync.Mutex
+	closed     bool
+	done       chan struct{} // closed when the server is closed
+	slots      chan struct{} // holds a value for each connection, if MaxConns > 0
+	listeners  map[net.Listener]struct{}
+	conns      map[*Conn]struct{}
+	disconnect sync.WaitGroup // the connections not yet disconnected
+}
+
+// Serve accepts connections from lis and serves them, until lis fails
+// or the server is closed. Temporary errors from lis.Accept are retried
+// after a delay. Serve always returns a non-nil error, which is
+// ErrServerClosed after a call to Shutdown or Close. lis is closed
+// when Serve returns.
+func (s *Server) Serve(lis net.Listener) error {
+	defer lis.Close()
+
+	s.mu.Lock()
+	s.init()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[lis] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, lis)
+		s.mu.Unlock()
+	}()
+
+	var tempDelay time.Duration // how long to sleep on accept failure
+	for {
+		if s.slots != nil {
+			select {
+			case s.slots <- struct{}{}:
+			case <-s.done:
+				return ErrServerClosed
+			}
+		}
+
+		nc, err := lis.Accept()
+		if err != nil {
+			s.releaseSlot()
+			select {
+			case <-s.done:
+				return ErrServerClosed
+			default:
+			}
+			// Retry temporary errors (such as running out of file
+			// descriptors) with a backoff, like net/http.
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				select {
+				case <-time.After(tempDelay):
+				case <-s.done:
+					return ErrServerClosed
+				}
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+		s.serveConn(nc)
+	}
+}
+
+// init initializes s. It must be called with s.mu held.
+func (s *Server) init() {
+	if s.done != nil {
+		return
+	}
+	s.done = make(chan struct{})
+	if s.MaxConns > 0 {
+		s.slots = make(chan struct{}, s.MaxConns)
+	}
+	s.listeners = map[net.Listener]struct{}{}
+	s.conns = map[*Conn]struct{}{}
+}
+
+// serveConn runs a Conn for the accepted connection nc.
+func (s *Server) serveConn(nc net.Conn) {
+	var stream ObjectStream
+	if s.Codec != nil {
+		stream = NewBufferedStream(nc, s.Codec)
+	} else {
+		stream = NewPlainObjectStream(nc)
+	}
+	// The Conn doesn't read messages until OnConnect returns, so that
+	// no handler runs before.
+	start := make(chan struct{})
+	opts := append(s.ConnOpts[:len(s.ConnOpts):len(s.ConnOpts)], readAfter(start))
+	conn := NewConn(context.Background(), stream, s.NewHandler(nc), opts...)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		s.releaseSlot()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.disconnect.Add(1)
+	s.mu.Unlock()
+
+	if s.OnConnect != nil {
+		s.OnConnect(conn)
+	}
+	close(start)
+	go func() {
+		<-conn.DisconnectNotify()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		if s.OnDisconnect != nil {
+			s.OnDisconnect(conn)
+		}
+		s.releaseSlot()
+		s.disconnect.Done()
+	}()
+}
+
+func (s *Server) releaseSlot() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// Shutdown gracefully shuts down the server: it stops accepting
+// connections, and then shuts down each connection with
+// (*Conn).Shutdown, which waits for its handlers and its pending calls.
+// If ctx is done first, the remaining connections are closed and
+// ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	conns := s.close()
+
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *Conn) {
+			defer wg.Done()
+			_ = conn.Shutdown(ctx)
+		}(conn)
+	}
+	wg.Wait()
+
+	// Also wait for OnDisconnect.
+	disconnected := make(chan struct{})
+	go func() {
+		s.disconnect.Wait()
+		close(disconnected)
+	}()
+	select {
+	case <-disconnected:
+	case <-ctx.Done():
+	}
+	return ctx.Err()
+}
+
+// Close immediately closes the listeners and the connections of the
+// server.
+func (s *Server) Close() error {
+	for _, conn := range s.close() {
+		conn.Close()
+	}
+	return nil
+}
+
+// close marks the server as closed and closes its listeners. It
+// returns the connections being served.
+func (s *Server) close() []*Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	for lis := range s.listeners {
+		lis.Close()
+	}
+	conns := make([]*Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
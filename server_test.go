@@ -0,0 +1,235 @@
+package jsonrpc2_test
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func newTestServer(t *testing.T, s *jsonrpc2.Server) (addr string, served <-chan error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(lis) }()
+	return lis.Addr().String(), errc
+}
+
+func dialTestServer(ctx context.Context, t *testing.T, addr string) *jsonrpc2.Conn {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jsonrpc2.NewConn(ctx, jsonrpc2.NewBufferedStream(nc, jsonrpc2.VSCodeObjectCodec{}), noopHandler{})
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+
+	connected := make(chan *jsonrpc2.Conn, 1)
+	disconnected := make(chan *jsonrpc2.Conn, 1)
+	s := &jsonrpc2.Server{
+		Codec: jsonrpc2.VSCodeObjectCodec{},
+		NewHandler: func(nc net.Conn) jsonrpc2.Handler {
+			return jsonrpc2.HandlerWithError(func(context.Context, *jsonrpc2.Conn, *jsonrpc2.Request) (interface{}, error) {
+				return nc.RemoteAddr().Network(), nil
+			})
+		},
+		OnConnect:    func(c *jsonrpc2.Conn) { connected <- c },
+		OnDisconnect: func(c *jsonrpc2.Conn) { disconnected <- c },
+	}
+	addr, served := newTestServer(t, s)
+
+	conn := dialTestServer(ctx, t, addr)
+	var got string
+	if err := conn.Call(ctx, "m", nil, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "tcp"; got != want {
+		t.Errorf("got result %q, want %q", got, want)
+	}
+
+	serverConn := <-connected
+	conn.Close()
+	if got := <-disconnected; got != serverConn {
+		t.Errorf("got OnDisconnect(%p), want OnDisconnect(%p)", got, serverConn)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != jsonrpc2.ErrServerClosed {
+		t.Errorf("got Serve error %v, want %v", err, jsonrpc2.ErrServerClosed)
+	}
+}
+
+func TestServer_OnConnect(t *testing.T) {
+	ctx := context.Background()
+
+	var onConnectDone int32
+	s := &jsonrpc2.Server{
+		NewHandler: func(net.Conn) jsonrpc2.Handler {
+			return jsonrpc2.HandlerWithError(func(context.Context, *jsonrpc2.Conn, *jsonrpc2.Request) (interface{}, error) {
+				return atomic.LoadInt32(&onConnectDone) == 1, nil
+			})
+		},
+		OnConnect: func(*jsonrpc2.Conn) {
+			time.Sleep(20 * time.Millisecond)
+			atomic.StoreInt32(&onConnectDone, 1)
+		},
+	}
+	addr, _ := newTestServer(t, s)
+	defer s.Close()
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(nc), noopHandler{})
+	defer conn.Close()
+	var got bool
+	if err := conn.Call(ctx, "m", nil, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got {
+		t.Error("request handled before OnConnect returned")
+	}
+}
+
+// temporaryErrorListener is a net.Listener whose first n calls to
+// Accept fail with a temporary error.
+type temporaryErrorListener struct {
+	net.Listener
+	n int
+}
+
+func (l *temporaryErrorListener) Accept() (net.Conn, error) {
+	if l.n > 0 {
+		l.n--
+		return nil, temporaryError{}
+	}
+	return l.Listener.Accept()
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary error" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+func TestServer_temporaryAcceptError(t *testing.T) {
+	ctx := context.Background()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &jsonrpc2.Server{
+		NewHandler: func(net.Conn) jsonrpc2.Handler {
+			return jsonrpc2.HandlerWithError(func(context.Context, *jsonrpc2.Conn, *jsonrpc2.Request) (interface{}, error) {
+				return nil, nil
+			})
+		},
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(&temporaryErrorListener{Listener: lis, n: 3}) }()
+
+	nc, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(nc), noopHandler{})
+	defer conn.Close()
+	if err := conn.Call(ctx, "m", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != jsonrpc2.ErrServerClosed {
+		t.Errorf("got Serve error %v, want %v", err, jsonrpc2.ErrServerClosed)
+	}
+}
+
+func TestServer_MaxConns(t *testing.T) {
+	ctx := context.Background()
+
+	connected := make(chan *jsonrpc2.Conn, 2)
+	s := &jsonrpc2.Server{
+		Codec:      jsonrpc2.VSCodeObjectCodec{},
+		NewHandler: func(net.Conn) jsonrpc2.Handler { return noopHandler{} },
+		MaxConns:   1,
+		OnConnect:  func(c *jsonrpc2.Conn) { connected <- c },
+	}
+	addr, _ := newTestServer(t, s)
+	defer s.Close()
+
+	conn1 := dialTestServer(ctx, t, addr)
+	<-connected
+	conn2 := dialTestServer(ctx, t, addr)
+	defer conn2.Close()
+	select {
+	case <-connected:
+		t.Fatal("second connection accepted while the first one is open")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	conn1.Close()
+	<-connected
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &jsonrpc2.Server{
+		NewHandler: func(net.Conn) jsonrpc2.Handler {
+			return jsonrpc2.HandlerWithError(func(context.Context, *jsonrpc2.Conn, *jsonrpc2.Request) (interface{}, error) {
+				close(started)
+				<-release
+				return "done", nil
+			})
+		},
+	}
+	addr, served := newTestServer(t, s)
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewPlainObjectStream(nc), noopHandler{})
+	defer conn.Close()
+	call, err := conn.DispatchCall(ctx, "m", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	shutdown := make(chan error)
+	go func() { shutdown <- s.Shutdown(ctx) }()
+	if err := <-served; err != jsonrpc2.ErrServerClosed {
+		t.Errorf("got Serve error %v, want %v", err, jsonrpc2.ErrServerClosed)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the handler was done", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	var got string
+	if err := call.Wait(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	<-conn.DisconnectNotify()
+}
@@ -0,0 +1,384 @@
+package jsonrpc2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// SSEHandler returns an http.Handler that carries JSON-RPC messages
+// over HTTP with Server-Sent Events, so that the server can send
+// requests and notifications to the client:
+//
+//   - A GET request opens a session. Its response is an event stream
+//     that starts with an "endpoint" event, whose data is the URL to
+//     which the client POSTs its messages, and then has a "message"
+//     event for each message sent by the server.
+//   - A POST request to the endpoint URL (which has a session query
+//     parameter) carries a message from the client in its body.
+//
+// For each session, newConn is called with the context of the GET
+// request and the ObjectStream of the session, typically to create a
+// Conn with NewConn. It must not block. The session ends when the
+// stream is closed or when the GET request ends.
+//
+// The body of a POST request may hold up to DefaultMaxHTTPBodySize
+// bytes; use MaxBodySize to change this limit. Larger requests get the
+// HTTP status 413 Request Entity Too Large.
+//
+// Use DialSSE to connect to an SSEHandler.
+func SSEHandler(newConn func(ctx context.Context, stream ObjectStream)) *SSEHandlerConfigurer {
+	return &SSEHandlerConfigurer{
+		newConn:     newConn,
+		maxBodySize: DefaultMaxHTTPBodySize,
+		sessions:    map[string]*sseServerStream{},
+	}
+}
+
+// SSEHandlerConfigurer is an http.Handler created by SSEHandler.
+type SSEHandlerConfigurer struct {
+	newConn     func(context.Context, ObjectStream)
+	maxBodySize int64
+
+	mu       sync.Mutex
+	sessions map[string]*sseServerStream
+}
+
+// MaxBodySize sets the maximum size in bytes of the body of the POST
+// requests. The original handler `h` is returned.
+func (h *SSEHandlerConfigurer) MaxBodySize(n int64) http.Handler {
+	h.maxBodySize = n
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *SSEHandlerConfigurer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.serveEvents(w, r)
+	case http.MethodPost:
+		h.serveMessage(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "jsonrpc2: method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveEvents serves the event stream of a new session.
+func (h *SSEHandlerConfigurer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "jsonrpc2: streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		http.Error(w, "jsonrpc2: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(b[:])
+	stream := &sseServerStream{
+		w:        w,
+		flusher:  flusher,
+		incoming: make(chan []byte),
+		closed:   make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	h.sessions[id] = stream
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.sessions, id)
+		h.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := stream.writeEvent("endpoint", []byte("?session="+id)); err != nil {
+		return
+	}
+
+	h.newConn(r.Context(), stream)
+	select {
+	case <-stream.closed:
+	case <-r.Context().Done():
+		stream.Close()
+	}
+}
+
+// serveMessage passes a message posted by the client to the stream of
+// its session.
+func (h *SSEHandlerConfigurer) serveMessage(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	stream := h.sessions[r.URL.Query().Get("session")]
+	h.mu.Unlock()
+	if stream == nil {
+		http.Error(w, "jsonrpc2: unknown session", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	if err != nil {
+		status := http.StatusBadRequest
+		if int64(len(body)) == h.maxBodySize {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, "jsonrpc2: reading request body: "+err.Error(), status)
+		return
+	}
+	select {
+	case stream.incoming <- body:
+		w.WriteHeader(http.StatusAccepted)
+	case <-stream.closed:
+		http.Error(w, "jsonrpc2: session closed", http.StatusNotFound)
+	case <-r.Context().Done():
+	}
+}
+
+// sseServerStream is the ObjectStream of a session of an SSEHandler.
+type sseServerStream struct {
+	incoming chan []byte // the bodies of the POST requests
+
+	mu      sync.Mutex // guards writes to w, and closing closed
+	w       io.Writer
+	flusher http.Flusher
+	closed  chan struct{}
+}
+
+// ReadObject implements ObjectStream.
+func (s *sseServerStream) ReadObject(v interface{}) error {
+	select {
+	case b := <-s.incoming:
+		if err := json.Unmarshal(b, v); err != nil {
+			return &DecodeError{Err: err}
+		}
+		return nil
+	case <-s.closed:
+		return io.EOF
+	}
+}
+
+// WriteObject implements ObjectStream.
+func (s *sseServerStream) WriteObject(obj interface{}) error {
+	b, err := marshalObject(obj)
+	if err != nil {
+		return err
+	}
+	return s.writeEvent("message", b)
+}
+
+// writeEvent writes an event to the event stream. Each line of data is
+// written in a data field of its own, since the raw JSON values of a
+// message may span several lines.
+func (s *sseServerStream) writeEvent(event string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.closed:
+		return io.ErrClosedPipe
+	default:
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "event: %s\n", event)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	if _, err := s.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Close implements ObjectStream.
+func (s *sseServerStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	return nil
+}
+
+// DialSSE opens a session with the SSEHandler at rawURL, and returns
+// its ObjectStream, typically to create a Conn with NewConn. Messages
+// from the server are read from the event stream of a GET request, and
+// messages to the server are sent with POST requests. If client is nil,
+// http.DefaultClient is used.
+//
+// The context is only used to open the session. Close the stream to
+// end the session.
+func DialSSE(ctx context.Context, rawURL string, client *http.Client) (ObjectStream, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	streamCtx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// Abort if ctx is done before the session is open.
+	dialing := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-dialing:
+		}
+	}()
+	s, err := openSSE(client, req, u)
+	close(dialing)
+	<-watcherDone
+	if err == nil && ctx.Err() != nil {
+		s.body.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.ctx = streamCtx
+	s.cancel = cancel
+	s.client = client
+	return s, nil
+}
+
+// openSSE sends the GET request req of a new session, and reads the
+// endpoint event.
+func openSSE(client *http.Client, req *http.Request, u *url.URL) (*sseClientStream, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("jsonrpc2: unexpected HTTP status %s", resp.Status)
+	}
+	s := &sseClientStream{body: resp.Body, events: bufio.NewReader(resp.Body)}
+	event, data, err := s.readEvent()
+	if err == nil && event != "endpoint" {
+		err = fmt.Errorf("jsonrpc2: got %q event, want endpoint event", event)
+	}
+	var endpoint *url.URL
+	if err == nil {
+		endpoint, err = url.Parse(string(data))
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	s.endpoint = u.ResolveReference(endpoint).String()
+	return s, nil
+}
+
+// sseClientStream is the ObjectStream returned by DialSSE.
+type sseClientStream struct {
+	ctx      context.Context // done when the stream is closed
+	cancel   context.CancelFunc
+	client   *http.Client
+	endpoint string
+
+	body   io.Closer
+	events *bufio.Reader
+}
+
+// ReadObject implements ObjectStream.
+func (s *sseClientStream) ReadObject(v interface{}) error {
+	for {
+		event, data, err := s.readEvent()
+		if err != nil {
+			return err
+		}
+		if event != "message" {
+			continue
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return &DecodeError{Err: err}
+		}
+		return nil
+	}
+}
+
+// readEvent reads the next event of the event stream.
+func (s *sseClientStream) readEvent() (event string, data []byte, err error) {
+	var lines [][]byte
+	for {
+		line, err := s.events.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) && (line != "" || len(lines) > 0) {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if event == "" && lines == nil {
+				continue // no event
+			}
+			if event == "" {
+				event = "message"
+			}
+			return event, bytes.Join(lines, []byte("\n")), nil
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			lines = append(lines, []byte(value))
+		}
+	}
+}
+
+// WriteObject implements ObjectStream.
+func (s *sseClientStream) WriteObject(obj interface{}) error {
+	b, err := marshalObject(obj)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("jsonrpc2: unexpected HTTP status %s", resp.Status)
+	}
+	return nil
+}
+
+// Close implements ObjectStream.
+func (s *sseClientStream) Close() error {
+	s.cancel()
+	return s.body.Close()
+}
@@ -0,0 +1,160 @@
+package jsonrpc2_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestSSE(t *testing.T) {
+	ctx := context.Background()
+
+	handler := handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+		// Notify the client before replying.
+		if err := conn.Notify(ctx, "progress", req.Method); err != nil {
+			t.Error(err)
+		}
+		if err := conn.Reply(ctx, req.ID, "hello"); err != nil {
+			t.Error(err)
+		}
+	})
+	serverConns := make(chan *jsonrpc2.Conn, 1)
+	srv := httptest.NewServer(jsonrpc2.SSEHandler(func(ctx context.Context, stream jsonrpc2.ObjectStream) {
+		serverConns <- jsonrpc2.NewConn(ctx, stream, jsonrpc2.AsyncHandler(handler))
+	}))
+	defer srv.Close()
+
+	stream, err := jsonrpc2.DialSSE(ctx, srv.URL+"/rpc", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	notified := make(chan string, 1)
+	conn := jsonrpc2.NewConn(ctx, stream, handlerFunc(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+		notified <- string(*req.Params)
+	}))
+	defer conn.Close()
+	serverConn := <-serverConns
+
+	var got string
+	if err := conn.Call(ctx, "m", nil, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello"; got != want {
+		t.Errorf("got result %q, want %q", got, want)
+	}
+	if got, want := <-notified, `"m"`; got != want {
+		t.Errorf("got notification params %s, want %s", got, want)
+	}
+
+	// Closing the client ends the session.
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-serverConn.DisconnectNotify()
+}
+
+func TestSSE_raw(t *testing.T) {
+	ctx := context.Background()
+
+	serverStreams := make(chan jsonrpc2.ObjectStream, 1)
+	srv := httptest.NewServer(jsonrpc2.SSEHandler(func(ctx context.Context, stream jsonrpc2.ObjectStream) {
+		serverStreams <- stream
+	}))
+	defer srv.Close()
+
+	clientStream, err := jsonrpc2.DialSSE(ctx, srv.URL, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientStream.Close()
+	serverStream := <-serverStreams
+
+	// The raw values are sent byte for byte, even over several lines.
+	data := "{\"id\":1,\"jsonrpc\":\"2.0\",\"method\":\"m\",\"params\":[1,\n 2.50,  \"<p>\"],\"sig\":{\"b\": \"<&>\"}}"
+	var r jsonrpc2.Request
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []struct {
+		name string
+		w, r jsonrpc2.ObjectStream
+	}{
+		{"to client", serverStream, clientStream},
+		{"to server", clientStream, serverStream},
+	} {
+		errc := make(chan error, 1)
+		go func() { errc <- dir.w.WriteObject(&r) }()
+		var got json.RawMessage
+		if err := dir.r.ReadObject(&got); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Errorf("%s: got message %q, want %q", dir.name, got, data)
+		}
+	}
+}
+
+func TestSSEHandler_unknownSession(t *testing.T) {
+	srv := httptest.NewServer(jsonrpc2.SSEHandler(func(ctx context.Context, stream jsonrpc2.ObjectStream) {
+		t.Error("unexpected session")
+	}))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"?session=x", "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"m"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestSSEHandler_MaxBodySize(t *testing.T) {
+	srv := httptest.NewServer(jsonrpc2.SSEHandler(func(ctx context.Context, stream jsonrpc2.ObjectStream) {
+		jsonrpc2.NewConn(ctx, stream, noopHandler{})
+	}).MaxBodySize(64))
+	defer srv.Close()
+
+	// Open a session, and read its endpoint.
+	events, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Body.Close()
+	r := bufio.NewReader(events.Body)
+	var endpoint string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			endpoint = strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+			break
+		}
+	}
+
+	for body, wantStatus := range map[string]int{
+		`{"jsonrpc":"2.0","method":"m"}`:                                            http.StatusAccepted,
+		`{"jsonrpc":"2.0","method":"m","params":"` + strings.Repeat("x", 64) + `"}`: http.StatusRequestEntityTooLarge,
+	} {
+		resp, err := http.Post(srv.URL+endpoint, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != wantStatus {
+			t.Errorf("%s: got status %d, want %d", body, resp.StatusCode, wantStatus)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // An ObjectStream is a bidirectional stream of JSON-RPC 2.0 objects.
@@ -23,6 +24,25 @@ type ObjectStream interface {
 	io.Closer
 }
 
+// ObjectsWriter is implemented by ObjectStreams that write several
+// objects at once more efficiently than with successive calls to
+// WriteObject, for instance with a single flush of a buffer. A Conn
+// uses it with OutboundQueue.
+type ObjectsWriter interface {
+	// WriteObjects writes JSON-RPC 2.0 objects to the stream.
+	WriteObjects(objs []interface{}) error
+}
+
+// WriteDeadlineSetter is implemented by ObjectStreams whose writes can
+// be interrupted with a deadline, such as the streams of a net.Conn
+// created by NewBufferedStream and NewPlainObjectStream. A Conn uses it
+// to stop writing a message once the context of the send is done.
+type WriteDeadlineSetter interface {
+	// SetWriteDeadline sets the deadline of the current and future
+	// writes (see net.Conn). A zero value means no deadline.
+	SetWriteDeadline(t time.Time) error
+}
+
 // A bufferedObjectStream is an ObjectStream that uses a buffered
 // io.ReadWriteCloser to send and receive objects.
 type bufferedObjectStream struct {
@@ -39,19 +59,37 @@ type bufferedObjectStream struct {
 // connection (or other similar interface). The underlying
 // objectStream is used to produce the bytes to write to the stream
 // for the JSON-RPC 2.0 objects.
+//
+// The stream implements WriteDeadlineSetter if conn does (as a
+// net.Conn does).
 func NewBufferedStream(conn io.ReadWriteCloser, codec ObjectCodec) ObjectStream {
 	switch v := codec.(type) {
 	case PlainObjectCodec:
 		v.decoder = json.NewDecoder(conn)
-		v.encoder = json.NewEncoder(conn)
 		codec = v
 	}
-	return &bufferedObjectStream{
+	t := &bufferedObjectStream{
 		conn:  conn,
 		w:     bufio.NewWriter(conn),
 		r:     bufio.NewReader(conn),
 		codec: codec,
 	}
+	if ds, ok := conn.(WriteDeadlineSetter); ok {
+		return deadlineBufferedObjectStream{t, ds}
+	}
+	return t
+}
+
+// deadlineBufferedObjectStream is a bufferedObjectStream whose
+// connection supports write deadlines.
+type deadlineBufferedObjectStream struct {
+	*bufferedObjectStream
+	ds WriteDeadlineSetter
+}
+
+// SetWriteDeadline implements WriteDeadlineSetter.
+func (t deadlineBufferedObjectStream) SetWriteDeadline(d time.Time) error {
+	return t.ds.SetWriteDeadline(d)
 }
 
 // WriteObject implements ObjectStream.
@@ -64,6 +102,18 @@ func (t *bufferedObjectStream) WriteObject(obj interface{}) error {
 	return t.w.Flush()
 }
 
+// WriteObjects implements ObjectsWriter, with a single flush.
+func (t *bufferedObjectStream) WriteObjects(objs []interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, obj := range objs {
+		if err := t.codec.WriteObject(t.w, obj); err != nil {
+			return err
+		}
+	}
+	return t.w.Flush()
+}
+
 // ReadObject implements ObjectStream.
 func (t *bufferedObjectStream) ReadObject(v interface{}) error {
 	return t.codec.ReadObject(t.r, v)
@@ -85,13 +135,27 @@ type ObjectCodec interface {
 	ReadObject(stream *bufio.Reader, v interface{}) error
 }
 
+// marshalObject returns the JSON encoding of obj. Unlike json.Marshal,
+// it doesn't compact the output of the MarshalJSON method of a message
+// nor escape the HTML characters in it, so that the raw JSON values of
+// a request (such as its ExtraFields) are written byte for byte.
+func marshalObject(obj interface{}) ([]byte, error) {
+	switch m := obj.(type) {
+	case *anyMessage:
+		return m.MarshalJSON()
+	case *Request:
+		return m.MarshalJSON()
+	}
+	return json.Marshal(obj)
+}
+
 // VarintObjectCodec reads/writes JSON-RPC 2.0 objects with a varint
 // header that encodes the byte length.
 type VarintObjectCodec struct{}
 
 // WriteObject implements ObjectCodec.
 func (VarintObjectCodec) WriteObject(stream io.Writer, obj interface{}) error {
-	data, err := json.Marshal(obj)
+	data, err := marshalObject(obj)
 	if err != nil {
 		return err
 	}
@@ -112,7 +176,7 @@ func (VarintObjectCodec) ReadObject(stream *bufio.Reader, v interface{}) error {
 	if err != nil {
 		return err
 	}
-	return json.NewDecoder(io.LimitReader(stream, int64(b))).Decode(v)
+	return decodeFrame(stream, int64(b), v)
 }
 
 // VSCodeObjectCodec reads/writes JSON-RPC 2.0 objects with
@@ -122,7 +186,7 @@ type VSCodeObjectCodec struct{}
 
 // WriteObject implements ObjectCodec.
 func (VSCodeObjectCodec) WriteObject(stream io.Writer, obj interface{}) error {
-	data, err := json.Marshal(obj)
+	data, err := marshalObject(obj)
 	if err != nil {
 		return err
 	}
@@ -166,23 +230,54 @@ func (VSCodeObjectCodec) ReadObject(stream *bufio.Reader, v interface{}) error {
 	if contentLength == 0 {
 		return fmt.Errorf("jsonrpc2: no Content-Length header found")
 	}
-	return json.NewDecoder(io.LimitReader(stream, int64(contentLength))).Decode(v)
+	return decodeFrame(stream, int64(contentLength), v)
 }
 
+// decodeFrame decodes the JSON object in the next n bytes of stream
+// into v. The whole frame is consumed, so that the next frame can be
+// read even if the object is malformed; in that case, a *DecodeError is
+// returned.
+func decodeFrame(stream io.Reader, n int64, v interface{}) error {
+	frame := &io.LimitedReader{R: stream, N: n}
+	err := json.NewDecoder(frame).Decode(v)
+	if _, drainErr := io.Copy(io.Discard, frame); drainErr != nil {
+		return drainErr
+	}
+	if err != nil && frame.N == 0 {
+		return &DecodeError{Err: err}
+	}
+	return err
+}
+
+// A DecodeError is returned by ObjectStream.ReadObject and
+// ObjectCodec.ReadObject when a whole message was read from the
+// stream, but could not be decoded. The stream remains usable: the next
+// call to ReadObject reads the next message.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return "jsonrpc2: invalid message: " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
 // PlainObjectCodec reads/writes plain JSON-RPC 2.0 objects without a header.
 //
 // Deprecated: use NewPlainObjectStream
 type PlainObjectCodec struct {
 	decoder *json.Decoder
-	encoder *json.Encoder
 }
 
 // WriteObject implements ObjectCodec.
 func (c PlainObjectCodec) WriteObject(stream io.Writer, v interface{}) error {
-	if c.encoder != nil {
-		return c.encoder.Encode(v)
+	data, err := marshalObject(v)
+	if err != nil {
+		return err
 	}
-	return json.NewEncoder(stream).Encode(v)
+	_, err = stream.Write(append(data, '\n'))
+	return err
 }
 
 // ReadObject implements ObjectCodec.
@@ -196,19 +291,38 @@ func (c PlainObjectCodec) ReadObject(stream *bufio.Reader, v interface{}) error
 // plainObjectStream reads/writes plain JSON-RPC 2.0 objects without a header.
 type plainObjectStream struct {
 	conn    io.Closer
+	w       io.Writer
 	decoder *json.Decoder
-	encoder *json.Encoder
 }
 
 // NewPlainObjectStream creates a buffered stream from a network
 // connection (or other similar interface). The underlying
 // objectStream produces plain JSON-RPC 2.0 objects without a header.
+//
+// The stream implements WriteDeadlineSetter if conn does (as a
+// net.Conn does).
 func NewPlainObjectStream(conn io.ReadWriteCloser) ObjectStream {
-	return &plainObjectStream{
+	os := &plainObjectStream{
 		conn:    conn,
-		encoder: json.NewEncoder(conn),
+		w:       conn,
 		decoder: json.NewDecoder(conn),
 	}
+	if ds, ok := conn.(WriteDeadlineSetter); ok {
+		return deadlinePlainObjectStream{os, ds}
+	}
+	return os
+}
+
+// deadlinePlainObjectStream is a plainObjectStream whose connection
+// supports write deadlines.
+type deadlinePlainObjectStream struct {
+	*plainObjectStream
+	ds WriteDeadlineSetter
+}
+
+// SetWriteDeadline implements WriteDeadlineSetter.
+func (os deadlinePlainObjectStream) SetWriteDeadline(t time.Time) error {
+	return os.ds.SetWriteDeadline(t)
 }
 
 func (os *plainObjectStream) ReadObject(v interface{}) error {
@@ -218,7 +332,12 @@ func (os *plainObjectStream) ReadObject(v interface{}) error {
 // WriteObject serializes a value to JSON and writes it to a stream.
 // Not thread-safe, a user must synchronize writes in a multithreaded environment.
 func (os *plainObjectStream) WriteObject(v interface{}) error {
-	return os.encoder.Encode(v)
+	data, err := marshalObject(v)
+	if err != nil {
+		return err
+	}
+	_, err = os.w.Write(append(data, '\n'))
+	return err
 }
 
 func (os *plainObjectStream) Close() error {
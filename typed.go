@@ -0,0 +1,37 @@
+package jsonrpc2
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// TypedHandler returns a handler that decodes the params of each request
+// into a value of type P and calls f with it. The result returned by f
+// is sent as the response, and errors are handled as by
+// HandlerWithError. If the params can't be decoded into a P, f is not
+// called and the request is replied to with a CodeInvalidParams error.
+//
+// Absent params are decoded as the zero value of P.
+func TypedHandler[P, R any](f func(context.Context, *Conn, P) (R, error)) *HandlerWithErrorConfigurer {
+	return HandlerWithError(func(ctx context.Context, conn *Conn, req *Request) (interface{}, error) {
+		var params P
+		if req.Params != nil {
+			if err := json.Unmarshal(*req.Params, &params); err != nil {
+				return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+			}
+		}
+		result, err := f(ctx, conn, params)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	})
+}
+
+// CallTyped is like (JSONRPC2).Call, but it returns the result of the
+// call decoded into a value of type R.
+func CallTyped[R any](ctx context.Context, conn JSONRPC2, method string, params interface{}, opts ...CallOption) (R, error) {
+	var result R
+	err := conn.Call(ctx, method, params, &result, opts...)
+	return result, err
+}
@@ -0,0 +1,57 @@
+package jsonrpc2_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+type addParams struct {
+	A, B int
+}
+
+func TestTypedHandler(t *testing.T) {
+	ctx := context.Background()
+
+	mux := jsonrpc2.NewServeMux()
+	mux.HandleRequest("add", jsonrpc2.TypedHandler(func(ctx context.Context, conn *jsonrpc2.Conn, p addParams) (int, error) {
+		return p.A + p.B, nil
+	}))
+	mux.HandleRequest("fail", jsonrpc2.TypedHandler(func(ctx context.Context, conn *jsonrpc2.Conn, p *addParams) (int, error) {
+		if p != nil {
+			t.Errorf("got params %+v, want nil", p)
+		}
+		return 0, errors.New("failed")
+	}))
+	connA, connB := Pipe(ctx, noopHandler{}, mux)
+	defer connA.Close()
+	defer connB.Close()
+
+	t.Run("result", func(t *testing.T) {
+		got, err := jsonrpc2.CallTyped[int](ctx, connA, "add", addParams{A: 1, B: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := 3; got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		_, err := jsonrpc2.CallTyped[int](ctx, connA, "add", "not an object")
+		respErr, ok := err.(*jsonrpc2.Error)
+		if !ok || respErr.Code != jsonrpc2.CodeInvalidParams {
+			t.Fatalf("got error %v, want code %d", err, jsonrpc2.CodeInvalidParams)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, err := jsonrpc2.CallTyped[int](ctx, connA, "fail", nil)
+		respErr, ok := err.(*jsonrpc2.Error)
+		if !ok || respErr.Message != "failed" {
+			t.Fatalf("got error %v, want %q", err, "failed")
+		}
+	})
+}
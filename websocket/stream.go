@@ -3,7 +3,9 @@
 package websocket
 
 import (
+	"encoding/json"
 	"io"
+	"time"
 
 	ws "github.com/gorilla/websocket"
 )
@@ -21,8 +23,19 @@ func NewObjectStream(conn *ws.Conn) ObjectStream {
 }
 
 // WriteObject implements jsonrpc2.ObjectStream.
+//
+// The messages of a jsonrpc2.Conn are written with their MarshalJSON
+// method directly, as WriteJSON would compact their raw JSON values.
 func (t ObjectStream) WriteObject(obj interface{}) error {
-	return t.conn.WriteJSON(obj)
+	m, ok := obj.(json.Marshaler)
+	if !ok {
+		return t.conn.WriteJSON(obj)
+	}
+	data, err := m.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return t.conn.WriteMessage(ws.TextMessage, data)
 }
 
 // ReadObject implements jsonrpc2.ObjectStream.
@@ -38,6 +51,11 @@ func (t ObjectStream) ReadObject(v interface{}) error {
 	return err
 }
 
+// SetWriteDeadline implements jsonrpc2.WriteDeadlineSetter.
+func (t ObjectStream) SetWriteDeadline(d time.Time) error {
+	return t.conn.SetWriteDeadline(d)
+}
+
 // Close implements jsonrpc2.ObjectStream.
 func (t ObjectStream) Close() error {
 	return t.conn.Close()